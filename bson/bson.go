@@ -16,6 +16,7 @@ package bson
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 )
@@ -92,6 +93,8 @@ func (bson *Bson) String() string {
 			_, err = fmt.Fprintf(buf, `"%s":%s`, it.Name(), it.Timestamp().String())
 		case BsonTypeInt64:
 			_, err = fmt.Fprintf(buf, `"%s":%v`, it.Name(), it.Int64())
+		case BsonTypeDecimal:
+			_, err = fmt.Fprintf(buf, `"%s":%s`, it.Name(), it.Decimal().String())
 		case BsonTypeMaxKey:
 			_, err = fmt.Fprintf(buf, `"%s":%s`, it.Name(), MaxKey.String())
 		case BsonTypeMinKey:
@@ -170,10 +173,44 @@ func (bson *Bson) Doc() Doc {
 	return d
 }
 
+// Struct decodes bson into the struct pointer s, the values that can't be decoded
+// into the fields, including the errors of their Unmarshalers, are skipped.
 func (bson *Bson) Struct(s interface{}) {
+	bson.StructWithOptions(s, nil)
+}
+
+// StructWithRegistry is like Struct but uses the decoders
+// registered in r, or DefaultRegistry if r is nil.
+func (bson *Bson) StructWithRegistry(s interface{}, r *Registry) {
+	bson.StructWithOptions(s, &DecodeOptions{Registry: r})
+}
+
+// StructWithOptions is like Struct but decodes with opts, the defaults are used if opts is nil.
+func (bson *Bson) StructWithOptions(s interface{}, opts *DecodeOptions) {
 	v := reflect.ValueOf(s)
 	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
 		panic("s must be struct pointer")
 	}
-	docToStruct(v.Elem(), bson.Doc())
+	bsonToStruct(v.Elem(), bson.raw, newDecoder(opts, false))
+}
+
+// StructE is like StructWithOptions but returns the error instead of panicking.
+// It is strict: the values that can't be decoded into the fields, e.g. strings into ints,
+// are errors instead of being skipped. The errors of the fields are *DecodeError.
+func (bson *Bson) StructE(s interface{}, opts *DecodeOptions) (err error) {
+	v := reflect.ValueOf(s)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("s must be struct pointer")
+	}
+
+	d := newDecoder(opts, true)
+	defer func() {
+		if r := recover(); r != nil {
+			// e.g. the errors of Unmarshalers and invalid struct tags
+			err = fmt.Errorf("can't decode field %q: %v", d.currentPath(), r)
+		}
+	}()
+
+	bsonToStruct(v.Elem(), bson.raw, d)
+	return d.err
 }
@@ -129,3 +129,140 @@ func TestNewBsonWithRaw(t *testing.T) {
 		t.Errorf("invalid Bson.Validate()")
 	}
 }
+
+func TestBsonBuilderRecordErrors(t *testing.T) {
+	var tests = []struct {
+		build func(b *bson.BsonBuilder)
+		err   string
+	}{
+		{func(b *bson.BsonBuilder) { b.Finish().AppendInt32("a", 1) }, "the bson builder is finished"},
+		{func(b *bson.BsonBuilder) { b.AppendObjectId("id", "bad") }, "invalid ObjectId"},
+		{func(b *bson.BsonBuilder) { b.AppendBinary("bin", bson.Binary{}) }, "binary is null"},
+		{func(b *bson.BsonBuilder) { b.Append("u", uint64(1<<63)) }, "bson has no uint64 type, and value is too large to fit correctly in an int64"},
+		{func(b *bson.BsonBuilder) { b.Append("c", complex(1, 2)) }, "can't append complex128((1+2i)) to bson"},
+		{func(b *bson.BsonBuilder) {
+			b.AppendBsonStart("obj").AppendInt32("a", 1).AppendObjectId("id", "bad").Finish().AppendBsonEnd()
+		}, "invalid ObjectId"},
+		{func(b *bson.BsonBuilder) {
+			b.AppendArrayStart("array").AppendInt32(1).Append(make(chan int)).Finish().AppendArrayEnd()
+		}, "can't append"},
+		{func(b *bson.BsonBuilder) {
+			b.AppendBsonStart("obj")
+			b.AppendInt32("a", 1)
+		}, "in child bson builder"},
+		{func(b *bson.BsonBuilder) { b.AppendBsonStart("obj").AppendBsonEnd() }, "the child bson builder is not finished"},
+	}
+
+	for i, test := range tests {
+		b := bson.NewBsonBuilder().RecordErrors()
+		test.build(b)
+		// no-ops after the first error
+		b.AppendString("after", "error").Append("after2", 1)
+		b.AppendDecimal("after3", bson.Decimal{Value: "bad"})
+
+		err := b.Err()
+		if err == nil || len(err.Error()) < len(test.err) || err.Error()[:len(test.err)] != test.err {
+			t.Errorf("case %d: expected error %q, actual %v", i, test.err, err)
+		}
+	}
+
+	b := bson.NewBsonBuilder().RecordErrors()
+	b.AppendInt32("a", 1).AppendBsonStart("b").AppendString("c", "d").Finish().AppendBsonEnd().Finish()
+	if b.Err() != nil || b.Bson().String() != `{"a":1, "b":{"c":"d"}}` {
+		t.Errorf("unexpected result: %v, %s", b.Err(), b.Bson())
+	}
+
+	a := bson.NewBsonArrayBuilder().RecordErrors()
+	a.AppendInt32(1).AppendBsonStart().AppendObjectId("id", "bad").Finish().AppendBsonEndToArray().AppendInt32(2)
+	if a.Err() == nil {
+		t.Errorf("expected error of array builder")
+	}
+}
+
+func TestBsonE(t *testing.T) {
+	if b, err := (bson.Doc{{"a", 1}, {"b", bson.Map{"c": "d"}}}).BsonE(); err != nil || b.String() != `{"a":1, "b":{"c":"d"}}` {
+		t.Errorf("unexpected result: %v, %v", b, err)
+	}
+	if b, err := (bson.Map{"a": 1}).BsonE(); err != nil || b.String() != `{"a":1}` {
+		t.Errorf("unexpected result: %v, %v", b, err)
+	}
+
+	type good struct {
+		A int
+	}
+	if b, err := bson.StructToBsonE(good{1}); err != nil || b.String() != `{"A":1}` {
+		t.Errorf("unexpected result: %v, %v", b, err)
+	}
+
+	type bad struct {
+		U uint64
+	}
+	type badTag struct {
+		A int `bson:",unknown"`
+	}
+
+	var tests = []func() (*bson.Bson, error){
+		bson.Doc{{"a", 1}, {"b", bson.Doc{{"c", func() {}}}}}.BsonE,
+		bson.Map{"a": bson.ObjectId("bad")}.BsonE,
+		func() (*bson.Bson, error) { return bson.StructToBsonE(bad{1 << 63}) },
+		func() (*bson.Bson, error) { return bson.StructToBsonE(badTag{}) },
+		func() (*bson.Bson, error) { return bson.StructToBsonE(1) },
+		func() (*bson.Bson, error) { return bson.StructToBsonE((*good)(nil)) },
+	}
+
+	for i, test := range tests {
+		b, err := test()
+		if err == nil || b != nil {
+			t.Errorf("case %d: expected error, actual %v", i, b)
+		}
+	}
+}
+
+func TestBsonBuilderReset(t *testing.T) {
+	b := bson.NewBsonBuilderSize(16).RecordErrors()
+	b.AppendString("a", "hello").AppendObjectId("id", "bad")
+	if b.Err() == nil {
+		t.Errorf("expected error")
+	}
+
+	b.Reset().AppendInt32("b", 1).AppendBsonStart("c").AppendBool("d", true).Finish().AppendBsonEnd().Finish()
+	if b.Err() != nil || b.Bson().String() != `{"b":1, "c":{"d":true}}` {
+		t.Errorf("unexpected result: %v, %s", b.Err(), b.Bson())
+	}
+
+	b.Reset().Finish()
+	if b.Bson().String() != "{}" {
+		t.Errorf("expected empty bson, actual %s", b.Bson())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic of resetting child builder")
+		}
+	}()
+	bson.NewBsonBuilder().AppendBsonStart("child").Reset()
+}
+
+func TestBsonBuilderPool(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		b := bson.AcquireBsonBuilder()
+		b.AppendDoc(bson.Doc{{"a", i}}).AppendMap(bson.Map{"b": "c"}).Finish()
+		want := bson.Doc{{"a", i}, {"b", "c"}}.Bson()
+		if b.Bson().String() != want.String() {
+			t.Errorf("expected: %s, actual: %s", want, b.Bson())
+		}
+		bson.ReleaseBsonBuilder(b)
+	}
+
+	// the settings are not kept in the pool
+	b := bson.AcquireBsonBuilder().RecordErrors().SetRegistry(bson.NewRegistry())
+	bson.ReleaseBsonBuilder(b)
+	b = bson.AcquireBsonBuilder()
+	defer bson.ReleaseBsonBuilder(b)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic of invalid ObjectId")
+		}
+	}()
+	b.AppendObjectId("id", "bad")
+}
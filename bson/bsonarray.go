@@ -63,6 +63,8 @@ func (array *BsonArray) String() string {
 			_, err = buf.WriteString(it.Timestamp().String())
 		case BsonTypeInt64:
 			_, err = fmt.Fprintf(buf, "%d", it.Int64())
+		case BsonTypeDecimal:
+			_, err = buf.WriteString(it.Decimal().String())
 		case BsonTypeMaxKey:
 			_, err = buf.WriteString(MaxKey.String())
 		case BsonTypeMinKey:
@@ -76,3 +76,91 @@ func TestBsonArray(t *testing.T) {
 		}
 	}
 }
+
+func TestBsonArrayAppendNested(t *testing.T) {
+	expected := `[{"a":1, "b":"x"}, [1, [2, {"c":true}]], "end"]`
+
+	a := bson.NewBsonArrayBuilder().
+		// append bson
+		AppendBsonStart().
+		AppendInt32("a", 1).
+		AppendString("b", "x").
+		Finish().
+		AppendBsonEndToArray().
+		// append array in array
+		AppendArrayStart().
+		AppendInt32(1).
+		AppendArrayStart().
+		AppendInt32(2).
+		AppendBsonStart().
+		AppendBool("c", true).
+		Finish().
+		AppendBsonEndToArray().
+		Finish().
+		AppendArrayEndToArray().
+		Finish().
+		AppendArrayEndToArray().
+		AppendString("end").
+		Finish().
+		BsonArray()
+
+	if a.String() != expected {
+		t.Errorf("append nested error, expected:%s, actual:%s", expected, a.String())
+	}
+
+	b := bson.NewBsonBuilder().
+		AppendArrayStart("array").
+		AppendBsonStart().
+		AppendInt32("a", 1).
+		Finish().
+		AppendBsonEndToArray().
+		Finish().
+		AppendArrayEnd().
+		Finish().
+		Bson()
+
+	if b.String() != `{"array":[{"a":1}]}` {
+		t.Errorf("append nested error, actual:%s", b.String())
+	}
+}
+
+func TestBsonArrayAppendNestedError(t *testing.T) {
+	var tests = []func(){
+		// not finished
+		func() { bson.NewBsonArrayBuilder().AppendBsonStart().AppendBsonEndToArray() },
+		func() { bson.NewBsonArrayBuilder().AppendArrayStart().AppendArrayEndToArray() },
+		// append to parent before the child is ended
+		func() {
+			a := bson.NewBsonArrayBuilder()
+			a.AppendBsonStart()
+			a.AppendInt32(1)
+		},
+		func() {
+			a := bson.NewBsonArrayBuilder()
+			a.AppendArrayStart().Finish()
+			a.AppendInt32(1)
+		},
+		// mismatched end
+		func() { bson.NewBsonArrayBuilder().AppendBsonStart().Finish().AppendBsonEnd() },
+		func() { bson.NewBsonArrayBuilder().AppendArrayStart().Finish().AppendArrayEnd() },
+		func() { bson.NewBsonBuilder().AppendBsonStart("a").Finish().AppendBsonEndToArray() },
+		func() { bson.NewBsonBuilder().AppendArrayStart("a").Finish().AppendArrayEndToArray() },
+		// end twice
+		func() {
+			child := bson.NewBsonArrayBuilder().AppendBsonStart().Finish()
+			child.AppendBsonEndToArray()
+			child.AppendBsonEndToArray()
+		},
+	}
+
+	for i, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("case %d: expected panic", i)
+				}
+			}()
+			test()
+		}()
+	}
+}
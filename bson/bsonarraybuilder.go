@@ -45,26 +45,59 @@ func NewBsonArrayBuilder() *BsonArrayBuilder {
 	return bsonArrayBuilder
 }
 
+// SetRegistry sets the registry used by Append of a and its child builders.
+func (a *BsonArrayBuilder) SetRegistry(r *Registry) *BsonArrayBuilder {
+	a.builder.SetRegistry(r)
+	return a
+}
+
+// SetObjectIdGenerator sets the generator used by AppendNewObjectId of a and its child builders.
+func (a *BsonArrayBuilder) SetObjectIdGenerator(g ObjectIdGenerator) *BsonArrayBuilder {
+	a.builder.SetObjectIdGenerator(g)
+	return a
+}
+
+// SortMapKeys sets whether the keys of maps are sorted by a and its child builders.
+func (a *BsonArrayBuilder) SortMapKeys(sort bool) *BsonArrayBuilder {
+	a.builder.SortMapKeys(sort)
+	return a
+}
+
+// RecordErrors turns on the error recording mode of a, see BsonBuilder.RecordErrors.
+func (a *BsonArrayBuilder) RecordErrors() *BsonArrayBuilder {
+	a.builder.RecordErrors()
+	return a
+}
+
+// Err returns the first error recorded in the error recording mode.
+func (a *BsonArrayBuilder) Err() error {
+	return a.builder.Err()
+}
+
 func (a *BsonArrayBuilder) Finish() *BsonArrayBuilder {
 	a.builder.Finish()
 	return a
 }
 
 func (child *BsonArrayBuilder) AppendArrayEnd() (parent *BsonBuilder) {
-	if child.builder.parent == nil {
-		panic("not in child array")
+	if child.builder.arrayParent != nil {
+		child.builder.fail("the parent is an array builder, use AppendArrayEndToArray")
+		return child.builder.parent
 	}
-	if !child.builder.finished {
-		panic("the child array is not finished")
+	return child.builder.end("array")
+}
+
+// AppendArrayEndToArray ends the child array started by BsonArrayBuilder.AppendArrayStart.
+func (child *BsonArrayBuilder) AppendArrayEndToArray() (parent *BsonArrayBuilder) {
+	if child.builder.parent != nil && child.builder.arrayParent == nil {
+		child.builder.fail("the parent is not an array builder, use AppendArrayEnd")
+		return nil
 	}
-	if child.builder.raw[len(child.builder.raw)-1] != eod {
-		panic("the child array is not finished")
+	parent = child.builder.arrayParent
+	child.builder.end("array")
+	if !child.builder.failed() {
+		child.builder.arrayParent = nil
 	}
-	parent = child.builder.parent
-	parent.raw = child.builder.raw
-	parent.child = nil
-	parent.inChild = false
-	child.builder.parent = nil
 	return parent
 }
 
@@ -94,6 +127,24 @@ func (a *BsonArrayBuilder) AppendBson(value *Bson) *BsonArrayBuilder {
 	return a
 }
 
+// AppendBsonStart starts a child document in place, which must be
+// finished and ended by AppendBsonEndToArray.
+func (a *BsonArrayBuilder) AppendBsonStart() (child *BsonBuilder) {
+	child = a.builder.AppendBsonStart(itoa(a.index))
+	child.arrayParent = a
+	a.index++
+	return child
+}
+
+// AppendArrayStart starts a child array in place, which must be
+// finished and ended by AppendArrayEndToArray.
+func (a *BsonArrayBuilder) AppendArrayStart() (child *BsonArrayBuilder) {
+	child = a.builder.AppendArrayStart(itoa(a.index))
+	child.builder.arrayParent = a
+	a.index++
+	return child
+}
+
 func (a *BsonArrayBuilder) AppendArray(value *BsonArray) *BsonArrayBuilder {
 	a.builder.AppendArray(itoa(a.index), value)
 	a.index++
@@ -112,6 +163,12 @@ func (a *BsonArrayBuilder) AppendObjectId(value ObjectId) *BsonArrayBuilder {
 	return a
 }
 
+func (a *BsonArrayBuilder) AppendNewObjectId() *BsonArrayBuilder {
+	a.builder.AppendNewObjectId(itoa(a.index))
+	a.index++
+	return a
+}
+
 func (a *BsonArrayBuilder) AppendBool(value bool) *BsonArrayBuilder {
 	a.builder.AppendBool(itoa(a.index), value)
 	a.index++
@@ -154,6 +211,18 @@ func (a *BsonArrayBuilder) AppendInt64(value int64) *BsonArrayBuilder {
 	return a
 }
 
+func (a *BsonArrayBuilder) AppendDecimal(value Decimal) *BsonArrayBuilder {
+	a.builder.AppendDecimal(itoa(a.index), value)
+	a.index++
+	return a
+}
+
+func (a *BsonArrayBuilder) AppendRaw(value RawValue) *BsonArrayBuilder {
+	a.builder.AppendRaw(itoa(a.index), value)
+	a.index++
+	return a
+}
+
 func (a *BsonArrayBuilder) AppendMinKey() *BsonArrayBuilder {
 	a.builder.AppendMinKey(itoa(a.index))
 	a.index++
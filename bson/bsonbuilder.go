@@ -15,12 +15,18 @@
 package bson
 
 import (
+	"encoding"
 	"fmt"
 	"math"
 	"reflect"
+	"sync"
+	"time"
 )
 
 const initialBufferSize = 64
+
+// the builders of larger buffers are not put back to the pool
+const maxPooledBufferSize = 64 * 1024
 const eod = byte(0x00) // end of doc
 
 type BsonBuilder struct {
@@ -30,6 +36,21 @@ type BsonBuilder struct {
 	parent   *BsonBuilder
 	inChild  bool
 	finished bool
+	registry *Registry
+	idGen    ObjectIdGenerator
+
+	// the keys of maps are appended in the iteration order if true
+	unsortedKeys bool
+
+	// the array builder if b is the child builder of an array element
+	arrayParent *BsonArrayBuilder
+
+	// the first error in the error recording mode, shared with the child builders
+	errs *builderError
+}
+
+type builderError struct {
+	err error
 }
 
 func NewBsonBuilder() *BsonBuilder {
@@ -38,6 +59,164 @@ func NewBsonBuilder() *BsonBuilder {
 	return b
 }
 
+// NewBsonBuilderSize returns a builder whose buffer has the capacity of size bytes,
+// the default size is used if size is not positive.
+func NewBsonBuilderSize(size int) *BsonBuilder {
+	if size <= 0 {
+		size = initialBufferSize
+	}
+	b := &BsonBuilder{raw: make([]byte, 0, size)}
+	b.reserveLength()
+	return b
+}
+
+// Reset discards the contents and the recorded error of b, and keeps its buffer
+// for reuse. The Bson returned by b before is overwritten by the new appends.
+func (b *BsonBuilder) Reset() *BsonBuilder {
+	if b.parent != nil || b.arrayParent != nil {
+		panic("can't reset the child bson builder")
+	}
+	b.raw = b.raw[:0]
+	b.offset = 0
+	b.child = nil
+	b.inChild = false
+	b.finished = false
+	if b.errs != nil {
+		b.errs = &builderError{}
+	}
+	b.reserveLength()
+	return b
+}
+
+var builderPool = sync.Pool{
+	New: func() interface{} {
+		return NewBsonBuilder()
+	},
+}
+
+// AcquireBsonBuilder returns an empty builder from the pool.
+// It should be released by ReleaseBsonBuilder once its Bson is no longer used.
+func AcquireBsonBuilder() *BsonBuilder {
+	return builderPool.Get().(*BsonBuilder)
+}
+
+// ReleaseBsonBuilder puts b back to the pool, b and its Bson must not be used after that.
+func ReleaseBsonBuilder(b *BsonBuilder) {
+	if b == nil || b.parent != nil || b.arrayParent != nil || cap(b.raw) > maxPooledBufferSize {
+		return
+	}
+	b.registry = nil
+	b.idGen = nil
+	b.unsortedKeys = false
+	b.errs = nil
+	b.Reset()
+	builderPool.Put(b)
+}
+
+// AppendDoc appends the elements of d.
+func (b *BsonBuilder) AppendDoc(d Doc) *BsonBuilder {
+	d.toBsonBuilder(b)
+	return b
+}
+
+// AppendMap appends the elements of m.
+func (b *BsonBuilder) AppendMap(m Map) *BsonBuilder {
+	m.toBsonBuilder(b)
+	return b
+}
+
+// SetRegistry sets the registry used by Append of b and its child builders.
+// DefaultRegistry is used if it is not set.
+func (b *BsonBuilder) SetRegistry(r *Registry) *BsonBuilder {
+	b.registry = r
+	return b
+}
+
+func (b *BsonBuilder) getRegistry() *Registry {
+	if b.registry != nil {
+		return b.registry
+	}
+	return DefaultRegistry
+}
+
+// SetObjectIdGenerator sets the generator used by AppendNewObjectId of b and its child builders.
+// The global generator is used if it is not set.
+func (b *BsonBuilder) SetObjectIdGenerator(g ObjectIdGenerator) *BsonBuilder {
+	b.idGen = g
+	return b
+}
+
+// SortMapKeys sets whether the keys of maps are sorted by b and its child builders.
+// They are sorted by default, so that a map is always encoded to the same bytes.
+func (b *BsonBuilder) SortMapKeys(sort bool) *BsonBuilder {
+	b.unsortedKeys = !sort
+	return b
+}
+
+func (b *BsonBuilder) newObjectId() ObjectId {
+	if b.idGen != nil {
+		return b.idGen.NewObjectId()
+	}
+	return NewObjectId()
+}
+
+// RecordErrors turns on the error recording mode of b and its child builders:
+// instead of panicking, the first error is recorded and returned by Err,
+// and the following appends are no-ops.
+func (b *BsonBuilder) RecordErrors() *BsonBuilder {
+	if b.errs == nil {
+		b.errs = &builderError{}
+	}
+	return b
+}
+
+// Err returns the first error recorded in the error recording mode.
+func (b *BsonBuilder) Err() error {
+	if b.errs == nil {
+		return nil
+	}
+	return b.errs.err
+}
+
+// fail panics with v, or records it if b is in the error recording mode.
+func (b *BsonBuilder) fail(v interface{}) {
+	if b.errs == nil {
+		panic(v)
+	}
+	if b.errs.err == nil {
+		b.errs.err = toError(v)
+	}
+}
+
+func (b *BsonBuilder) failed() bool {
+	return b.errs != nil && b.errs.err != nil
+}
+
+// buildE builds a document by f in the error recording mode. The panics
+// of f, e.g. of invalid struct tags, are returned as errors too.
+func buildE(f func(b *BsonBuilder)) (bson *Bson, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			bson, err = nil, toError(r)
+		}
+	}()
+
+	b := NewBsonBuilder().RecordErrors()
+	f(b)
+	b.Finish()
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+	return b.Bson(), nil
+}
+
+func toError(v interface{}) error {
+	if err, ok := v.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", v)
+}
+
 func (b *BsonBuilder) reserveLength() {
 	b.raw = append(b.raw, 0, 0, 0, 0)
 }
@@ -79,18 +258,28 @@ func (b *BsonBuilder) appendFloat64(v float64) {
 	b.appendInt64(u)
 }
 
-func (b *BsonBuilder) checkBeforeAppend() {
+// checkBeforeAppend reports whether b can be appended.
+func (b *BsonBuilder) checkBeforeAppend() bool {
+	if b.failed() {
+		return false
+	}
+
 	if b.finished {
-		panic("the bson builder is finished")
+		b.fail("the bson builder is finished")
+		return false
 	}
 
 	if b.inChild {
-		panic("in child bson builder")
+		b.fail("in child bson builder")
+		return false
 	}
+	return true
 }
 
 func (b *BsonBuilder) Finish() *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.raw = append(b.raw, eod)
 	b.setLength(int32(len(b.raw) - b.offset))
 	b.finished = true
@@ -106,7 +295,9 @@ func (b *BsonBuilder) Bson() *Bson {
 }
 
 func (b *BsonBuilder) AppendFloat64(name string, value float64) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeFloat64)
 	b.appendCString(name)
 	b.appendFloat64(value)
@@ -114,7 +305,9 @@ func (b *BsonBuilder) AppendFloat64(name string, value float64) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendString(name string, value string) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeString)
 	b.appendCString(name)
 	b.appendInt32(int32(len(value) + 1))
@@ -123,7 +316,9 @@ func (b *BsonBuilder) AppendString(name string, value string) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendBson(name string, value *Bson) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeBson)
 	b.appendCString(name)
 	b.appendBytes(value.Raw()...)
@@ -131,10 +326,13 @@ func (b *BsonBuilder) AppendBson(name string, value *Bson) *BsonBuilder {
 }
 
 func (parent *BsonBuilder) AppendBsonStart(name string) (child *BsonBuilder) {
-	parent.checkBeforeAppend()
+	if !parent.checkBeforeAppend() {
+		// a detached child whose appends are no-ops
+		return &BsonBuilder{parent: parent, errs: parent.errs}
+	}
 	parent.appendType(BsonTypeBson)
 	parent.appendCString(name)
-	child = &BsonBuilder{raw: parent.raw, offset: len(parent.raw)}
+	child = &BsonBuilder{raw: parent.raw, offset: len(parent.raw), registry: parent.registry, idGen: parent.idGen, unsortedKeys: parent.unsortedKeys, errs: parent.errs}
 	child.reserveLength()
 	parent.inChild = true
 	parent.child = child
@@ -143,14 +341,39 @@ func (parent *BsonBuilder) AppendBsonStart(name string) (child *BsonBuilder) {
 }
 
 func (child *BsonBuilder) AppendBsonEnd() (parent *BsonBuilder) {
-	if child.parent == nil {
-		panic("not in child bson builder")
+	if child.arrayParent != nil {
+		child.fail("the parent is an array builder, use AppendBsonEndToArray")
+		return child.parent
 	}
-	if !child.finished {
-		panic("the child bson builder is not finished")
+	return child.end("bson builder")
+}
+
+// AppendBsonEndToArray ends the child document started by BsonArrayBuilder.AppendBsonStart.
+func (child *BsonBuilder) AppendBsonEndToArray() (parent *BsonArrayBuilder) {
+	if child.parent != nil && child.arrayParent == nil {
+		child.fail("the parent is not an array builder, use AppendBsonEnd")
+		return nil
 	}
-	if child.raw[len(child.raw)-1] != eod {
-		panic("the child bson builder is not finished")
+	parent = child.arrayParent
+	child.end("bson builder")
+	if !child.failed() {
+		child.arrayParent = nil
+	}
+	return parent
+}
+
+// end checks that the child builder is finished, and returns to its parent.
+func (child *BsonBuilder) end(what string) (parent *BsonBuilder) {
+	if child.failed() {
+		return child.parent
+	}
+	if child.parent == nil {
+		child.fail("not in child " + what)
+		return child
+	}
+	if !child.finished || child.raw[len(child.raw)-1] != eod {
+		child.fail("the child " + what + " is not finished")
+		return child.parent
 	}
 	parent = child.parent
 	parent.raw = child.raw
@@ -161,7 +384,9 @@ func (child *BsonBuilder) AppendBsonEnd() (parent *BsonBuilder) {
 }
 
 func (b *BsonBuilder) AppendArray(name string, value *BsonArray) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeArray)
 	b.appendCString(name)
 	b.appendBytes(value.Raw()...)
@@ -169,10 +394,13 @@ func (b *BsonBuilder) AppendArray(name string, value *BsonArray) *BsonBuilder {
 }
 
 func (parent *BsonBuilder) AppendArrayStart(name string) (child *BsonArrayBuilder) {
-	parent.checkBeforeAppend()
+	if !parent.checkBeforeAppend() {
+		// a detached child whose appends are no-ops
+		return &BsonArrayBuilder{builder: BsonBuilder{parent: parent, errs: parent.errs}}
+	}
 	parent.appendType(BsonTypeArray)
 	parent.appendCString(name)
-	child = &BsonArrayBuilder{builder: BsonBuilder{raw: parent.raw, offset: len(parent.raw)}}
+	child = &BsonArrayBuilder{builder: BsonBuilder{raw: parent.raw, offset: len(parent.raw), registry: parent.registry, idGen: parent.idGen, unsortedKeys: parent.unsortedKeys, errs: parent.errs}}
 	child.builder.reserveLength()
 	child.builder.parent = parent
 	parent.inChild = true
@@ -181,9 +409,12 @@ func (parent *BsonBuilder) AppendArrayStart(name string) (child *BsonArrayBuilde
 }
 
 func (b *BsonBuilder) AppendBinary(name string, value Binary) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	if value.Data == nil {
-		panic("binary is null")
+		b.fail("binary is null")
+		return b
 	}
 	b.appendType(BsonTypeBinary)
 	b.appendCString(name)
@@ -194,9 +425,12 @@ func (b *BsonBuilder) AppendBinary(name string, value Binary) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendObjectId(name string, value ObjectId) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	if !value.IsValid() {
-		panic(fmt.Sprintf("invalid ObjectId: %s", value))
+		b.fail(fmt.Sprintf("invalid ObjectId: %s", value))
+		return b
 	}
 	b.appendType(BsonTypeObjectId)
 	b.appendCString(name)
@@ -204,8 +438,15 @@ func (b *BsonBuilder) AppendObjectId(name string, value ObjectId) *BsonBuilder {
 	return b
 }
 
+// AppendNewObjectId appends a new ObjectId generated by the ObjectIdGenerator of b.
+func (b *BsonBuilder) AppendNewObjectId(name string) *BsonBuilder {
+	return b.AppendObjectId(name, b.newObjectId())
+}
+
 func (b *BsonBuilder) AppendBool(name string, value bool) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeBool)
 	b.appendCString(name)
 	if value {
@@ -217,7 +458,9 @@ func (b *BsonBuilder) AppendBool(name string, value bool) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendDate(name string, value Date) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeDate)
 	b.appendCString(name)
 	b.appendInt64(int64(value))
@@ -225,14 +468,18 @@ func (b *BsonBuilder) AppendDate(name string, value Date) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendNull(name string) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeNull)
 	b.appendCString(name)
 	return b
 }
 
 func (b *BsonBuilder) AppendRegex(name string, value RegEx) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeRegEx)
 	b.appendCString(name)
 	b.appendCString(value.Pattern)
@@ -241,7 +488,9 @@ func (b *BsonBuilder) AppendRegex(name string, value RegEx) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendInt32(name string, value int32) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeInt32)
 	b.appendCString(name)
 	b.appendInt32(value)
@@ -249,7 +498,9 @@ func (b *BsonBuilder) AppendInt32(name string, value int32) *BsonBuilder {
 }
 
 func (b *BsonBuilder) AppendTimestamp(name string, value Timestamp) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeTimestamp)
 	b.appendCString(name)
 	b.appendInt32(value.Increment)
@@ -258,28 +509,77 @@ func (b *BsonBuilder) AppendTimestamp(name string, value Timestamp) *BsonBuilder
 }
 
 func (b *BsonBuilder) AppendInt64(name string, value int64) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeInt64)
 	b.appendCString(name)
 	b.appendInt64(value)
 	return b
 }
 
+func (b *BsonBuilder) AppendDecimal(name string, value Decimal) *BsonBuilder {
+	if !b.checkBeforeAppend() {
+		return b
+	}
+	raw, err := value.encode()
+	if err != nil {
+		b.fail(err)
+		return b
+	}
+	b.appendType(BsonTypeDecimal)
+	b.appendCString(name)
+	b.appendBytes(raw...)
+	return b
+}
+
+// AppendRaw appends the bytes of value verbatim, value must be valid.
+func (b *BsonBuilder) AppendRaw(name string, value RawValue) *BsonBuilder {
+	if err := checkValue(value.Type, value.Value); err != nil {
+		b.fail(err)
+		return b
+	}
+	return b.appendRaw(name, value)
+}
+
+func (b *BsonBuilder) appendRaw(name string, value RawValue) *BsonBuilder {
+	if !b.checkBeforeAppend() {
+		return b
+	}
+	b.appendType(value.Type)
+	b.appendCString(name)
+	b.appendBytes(value.Value...)
+	return b
+}
+
 func (b *BsonBuilder) AppendMinKey(name string) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeMinKey)
 	b.appendCString(name)
 	return b
 }
 
 func (b *BsonBuilder) AppendMaxKey(name string) *BsonBuilder {
-	b.checkBeforeAppend()
+	if !b.checkBeforeAppend() {
+		return b
+	}
 	b.appendType(BsonTypeMaxKey)
 	b.appendCString(name)
 	return b
 }
 
 func (bson *BsonBuilder) Append(name string, value interface{}) {
+	if bson.failed() {
+		return
+	}
+	if r := bson.getRegistry().active(); r != nil && value != nil {
+		if r.encode(bson, name, reflect.ValueOf(value)) {
+			return
+		}
+	}
+
 	switch value.(type) {
 	case float32:
 		bson.AppendFloat64(name, float64(value.(float32)))
@@ -312,7 +612,8 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 	case uint64:
 		val := int64(value.(uint64))
 		if val < 0 {
-			panic("bson has no uint64 type, and value is too large to fit correctly in an int64")
+			bson.fail("bson has no uint64 type, and value is too large to fit correctly in an int64")
+			return
 		}
 		if val >= math.MinInt32 && val <= math.MaxInt32 {
 			bson.AppendInt32(name, int32(val))
@@ -329,7 +630,8 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 	case uint:
 		val := int64(value.(uint))
 		if val < 0 {
-			panic("bson has no uint64 type, and value is too large to fit correctly in an int64")
+			bson.fail("bson has no uint64 type, and value is too large to fit correctly in an int64")
+			return
 		}
 		if val >= math.MinInt32 && val <= math.MaxInt32 {
 			bson.AppendInt32(name, int32(val))
@@ -339,7 +641,8 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 	case uintptr:
 		val := int64(value.(uintptr))
 		if val < 0 {
-			panic("bson has no uint64 type, and value is too large to fit correctly in an int64")
+			bson.fail("bson has no uint64 type, and value is too large to fit correctly in an int64")
+			return
 		}
 		if val >= math.MinInt32 && val <= math.MaxInt32 {
 			bson.AppendInt32(name, int32(val))
@@ -356,12 +659,16 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 		bson.AppendObjectId(name, value.(ObjectId))
 	case Date:
 		bson.AppendDate(name, value.(Date))
+	case time.Time:
+		bson.AppendDate(name, NewDate(value.(time.Time)))
 	case RegEx:
 		bson.AppendRegex(name, value.(RegEx))
 	case Timestamp:
 		bson.AppendTimestamp(name, value.(Timestamp))
 	case Binary:
 		bson.AppendBinary(name, value.(Binary))
+	case Decimal:
+		bson.AppendDecimal(name, value.(Decimal))
 	case orderKey:
 		val := value.(orderKey)
 		if val == MaxKey {
@@ -369,22 +676,66 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 		} else if val == MinKey {
 			bson.AppendMinKey(name)
 		} else {
-			panic("invalid orderkey")
+			bson.fail("invalid orderkey")
 		}
 	case Map:
 		m := value.(Map)
 		child := bson.AppendBsonStart(name)
 		m.toBsonBuilder(child)
 		child.Finish()
-		bson.AppendBsonEnd()
+		child.AppendBsonEnd()
+	case map[string]interface{}:
+		m := Map(value.(map[string]interface{}))
+		child := bson.AppendBsonStart(name)
+		m.toBsonBuilder(child)
+		child.Finish()
+		child.AppendBsonEnd()
+	case map[string]string:
+		child := bson.AppendBsonStart(name)
+		appendStringMap(child, value.(map[string]string))
+		child.Finish()
+		child.AppendBsonEnd()
 	case Doc:
 		d := value.(Doc)
 		child := bson.AppendBsonStart(name)
 		d.toBsonBuilder(child)
 		child.Finish()
 		child.AppendBsonEnd()
+	case *Bson:
+		if value.(*Bson) == nil {
+			bson.AppendNull(name)
+		} else {
+			bson.AppendBson(name, value.(*Bson))
+		}
+	case *BsonArray:
+		if value.(*BsonArray) == nil {
+			bson.AppendNull(name)
+		} else {
+			bson.AppendArray(name, value.(*BsonArray))
+		}
+	case RawValue:
+		bson.AppendRaw(name, value.(RawValue))
 	default:
+		if m, ok := value.(Marshaler); ok {
+			bson.appendMarshaler(name, m)
+			return
+		}
 		v := reflect.ValueOf(value)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				bson.AppendNull(name)
+			} else if m, ok := value.(encoding.TextMarshaler); ok && !v.Elem().Type().Implements(typeTextMarshaler) {
+				// MarshalText with pointer receiver
+				bson.appendTextMarshaler(name, m)
+			} else {
+				bson.Append(name, v.Elem().Interface())
+			}
+			return
+		}
+		if m, ok := value.(encoding.TextMarshaler); ok {
+			bson.appendTextMarshaler(name, m)
+			return
+		}
 		switch v.Kind() {
 		case reflect.Array, reflect.Slice:
 			l := v.Len()
@@ -396,20 +747,16 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 			child.AppendArrayEnd()
 			return
 		case reflect.Map:
-			child := bson.AppendBsonStart(name)
-			for _, k := range v.MapKeys() {
-				child.Append(k.String(), v.MapIndex(k).Interface())
+			elems, err := bson.mapElements(v)
+			if err != nil {
+				bson.fail(err)
+				return
 			}
+			child := bson.AppendBsonStart(name)
+			elems.toBsonBuilder(child)
 			child.Finish()
 			child.AppendBsonEnd()
 			return
-		case reflect.Ptr:
-			if v.IsNil() {
-				bson.AppendNull(name)
-			} else {
-				bson.Append(name, v.Elem().Interface())
-			}
-			return
 		case reflect.Struct:
 			child := bson.AppendBsonStart(name)
 			structToBsonBuilder(v, child)
@@ -420,6 +767,6 @@ func (bson *BsonBuilder) Append(name string, value interface{}) {
 		// Complex64, Complex128
 		// Chan, Func
 		// UnsafePointer
-		panic(fmt.Errorf("can't append %s(%v) to bson", reflect.TypeOf(value).String(), value))
+		bson.fail(fmt.Errorf("can't append %s(%v) to bson", reflect.TypeOf(value).String(), value))
 	}
 }
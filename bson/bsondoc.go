@@ -14,6 +14,13 @@
 
 package bson
 
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
 type Doc []DocElement
 
 type DocElement struct {
@@ -28,12 +35,17 @@ func (d Doc) toBsonBuilder(b *BsonBuilder) {
 }
 
 func (d Doc) Bson() *Bson {
-	b := NewBsonBuilder()
+	b := NewBsonBuilderSize(docSize(d))
 	d.toBsonBuilder(b)
 	b.Finish()
 	return b.Bson()
 }
 
+// BsonE is like Bson but returns the error instead of panicking.
+func (d Doc) BsonE() (*Bson, error) {
+	return buildE(d.toBsonBuilder)
+}
+
 func (d Doc) Map() Map {
 	m := Map{}
 	for _, e := range d {
@@ -45,3 +57,270 @@ func (d Doc) Map() Map {
 func (d Doc) String() string {
 	return d.Bson().String()
 }
+
+// Index returns the index of the first element named name, or -1 if there is none.
+func (d Doc) Index(name string) int {
+	for i := range d {
+		if d[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of the first element named name.
+func (d Doc) Get(name string) (interface{}, bool) {
+	if i := d.Index(name); i >= 0 {
+		return d[i].Value, true
+	}
+	return nil, false
+}
+
+// Lookup returns the value of the dotted path, e.g. "a.b.0.c", through the nested
+// Docs, Maps and []interface{}, where the numbers are the indexes of arrays.
+func (d Doc) Lookup(path string) (interface{}, bool) {
+	var v interface{} = d
+	for _, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case Doc:
+			var ok bool
+			if v, ok = x.Get(key); !ok {
+				return nil, false
+			}
+		case Map:
+			var ok bool
+			if v, ok = x[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil, false
+			}
+			v = x[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Set replaces the value of the first element named name, or appends the element if there is none.
+func (d *Doc) Set(name string, v interface{}) {
+	if i := d.Index(name); i >= 0 {
+		(*d)[i].Value = v
+		return
+	}
+	*d = append(*d, DocElement{name, v})
+}
+
+// Delete removes the elements named name and keeps the order of the others,
+// it reports whether any element is removed.
+func (d *Doc) Delete(name string) bool {
+	n := 0
+	for _, e := range *d {
+		if e.Name != name {
+			(*d)[n] = e
+			n++
+		}
+	}
+	deleted := n < len(*d)
+	for i := n; i < len(*d); i++ {
+		(*d)[i] = DocElement{}
+	}
+	*d = (*d)[:n]
+	return deleted
+}
+
+// Keys returns the names of the elements in order.
+func (d Doc) Keys() []string {
+	keys := make([]string, len(d))
+	for i, e := range d {
+		keys[i] = e.Name
+	}
+	return keys
+}
+
+// Clone returns a deep copy of d: the nested Docs, Maps, binaries and bsons, and the slices,
+// arrays, maps, pointers and exported struct fields of any type are copied too.
+// The values must not contain pointer cycles.
+func (d Doc) Clone() Doc {
+	if d == nil {
+		return nil
+	}
+	c := make(Doc, len(d))
+	for i, e := range d {
+		c[i] = DocElement{e.Name, cloneValue(e.Value)}
+	}
+	return c
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case Doc:
+		return x.Clone()
+	case Map:
+		if x == nil {
+			return x
+		}
+		m := make(Map, len(x))
+		for name, v := range x {
+			m[name] = cloneValue(v)
+		}
+		return m
+	case []interface{}:
+		if x == nil {
+			return x
+		}
+		a := make([]interface{}, len(x))
+		for i, v := range x {
+			a[i] = cloneValue(v)
+		}
+		return a
+	case Binary:
+		if x.Data != nil {
+			x.Data = append([]byte{}, x.Data...)
+		}
+		return x
+	case *Bson:
+		if x == nil {
+			return x
+		}
+		return &Bson{raw: append([]byte{}, x.raw...)}
+	case *BsonArray:
+		if x == nil {
+			return x
+		}
+		return &BsonArray{bson: Bson{raw: append([]byte{}, x.bson.raw...)}}
+	case nil:
+		return nil
+	default:
+		return cloneReflect(reflect.ValueOf(v)).Interface()
+	}
+}
+
+// cloneReflect returns a deep copy of v, the elements are cloned by cloneValue.
+func cloneReflect(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		cloneElements(c, v)
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		cloneElements(c, v)
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		it := v.MapRange()
+		for it.Next() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			setClone(e, it.Value())
+			c.SetMapIndex(it.Key(), e)
+		}
+		return c
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		setClone(c.Elem(), v.Elem())
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			// the private fields are copied by value
+			if c.Field(i).CanSet() {
+				setClone(c.Field(i), v.Field(i))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// cloneElements sets the clones of the elements of the slice or array v to c.
+func cloneElements(c, v reflect.Value) {
+	switch v.Type().Elem().Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+		reflect.Copy(c, v)
+		return
+	}
+	for i := 0; i < v.Len(); i++ {
+		setClone(c.Index(i), v.Index(i))
+	}
+}
+
+// setClone sets the clone of v to the settable dst.
+func setClone(dst, v reflect.Value) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	dst.Set(reflect.ValueOf(cloneValue(v.Interface())))
+}
+
+// Equal reports whether d and o have the same elements in the same order.
+// The values are compared by reflect.DeepEqual, so int32(1) and int64(1) are different,
+// except that the nested Docs, Maps, slices and bsons are compared element by element.
+func (d Doc) Equal(o Doc) bool {
+	if len(d) != len(o) {
+		return false
+	}
+	for i := range d {
+		if d[i].Name != o[i].Name || !valueEqual(d[i].Value, o[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func valueEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case Doc:
+		y, ok := b.(Doc)
+		return ok && x.Equal(y)
+	case Map:
+		y, ok := b.(Map)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, v := range x {
+			if w, exist := y[name]; !exist || !valueEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !valueEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case *Bson:
+		y, ok := b.(*Bson)
+		return ok && (x == nil) == (y == nil) && (x == nil || bytes.Equal(x.raw, y.raw))
+	case *BsonArray:
+		y, ok := b.(*BsonArray)
+		return ok && (x == nil) == (y == nil) && (x == nil || bytes.Equal(x.bson.raw, y.bson.raw))
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
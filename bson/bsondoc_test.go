@@ -49,3 +49,163 @@ func TestDoc(t *testing.T) {
 		t.Errorf("doc convert bson error, expected:%s, actual:%s", expected, doc2.String())
 	}
 }
+
+func TestDocGetSetDelete(t *testing.T) {
+	d := bson.Doc{{"a", 1}, {"b", "x"}, {"a", 2}}
+
+	if v, ok := d.Get("a"); !ok || v != 1 {
+		t.Errorf("expected 1, actual %v", v)
+	}
+	if _, ok := d.Get("c"); ok {
+		t.Errorf("unexpected element c")
+	}
+	if i := d.Index("b"); i != 1 {
+		t.Errorf("expected index 1, actual %d", i)
+	}
+	if i := d.Index("c"); i != -1 {
+		t.Errorf("expected index -1, actual %d", i)
+	}
+
+	d.Set("b", "y")
+	d.Set("c", true)
+	if !d.Equal(bson.Doc{{"a", 1}, {"b", "y"}, {"a", 2}, {"c", true}}) {
+		t.Errorf("unexpected doc after set: %v", d)
+	}
+
+	if !d.Delete("a") || d.Delete("x") {
+		t.Errorf("unexpected result of delete")
+	}
+	if keys := d.Keys(); len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	var empty bson.Doc
+	empty.Set("a", 1)
+	if len(empty) != 1 {
+		t.Errorf("unexpected doc: %v", empty)
+	}
+}
+
+func TestDocLookup(t *testing.T) {
+	d := bson.Doc{
+		{"a", bson.Doc{{"b", []interface{}{1, bson.Doc{{"c", "x"}}}}}},
+		{"m", bson.Map{"n": bson.Doc{{"o", 2}}}},
+		{"i", 3},
+	}
+
+	var tests = []struct {
+		path  string
+		value interface{}
+		found bool
+	}{
+		{"i", 3, true},
+		{"a.b.0", 1, true},
+		{"a.b.1.c", "x", true},
+		{"m.n.o", 2, true},
+		{"a.b.2", nil, false},
+		{"a.b.-1", nil, false},
+		{"a.b.x", nil, false},
+		{"a.c", nil, false},
+		{"i.j", nil, false},
+		{"", nil, false},
+	}
+
+	for _, test := range tests {
+		v, found := d.Lookup(test.path)
+		if found != test.found || v != test.value {
+			t.Errorf("%q: expected %v %v, actual %v %v", test.path, test.value, test.found, v, found)
+		}
+	}
+
+	// the decoded docs
+	d2 := d.Bson().Doc()
+	if v, _ := d2.Lookup("a.b.1.c"); v != "x" {
+		t.Errorf("expected x, actual %v", v)
+	}
+}
+
+func TestDocCloneEqual(t *testing.T) {
+	d := bson.Doc{
+		{"doc", bson.Doc{{"a", 1}}},
+		{"map", bson.Map{"b": []interface{}{2}}},
+		{"array", []interface{}{bson.Doc{{"c", 3}}}},
+		{"binary", bson.Binary{Subtype: bson.BinaryTypeGeneral, Data: []byte{1, 2}}},
+		{"bson", bson.Doc{{"d", 4}}.Bson()},
+	}
+
+	c := d.Clone()
+	if !c.Equal(d) || !d.Equal(c) {
+		t.Errorf("expected equal docs: %v, %v", d, c)
+	}
+
+	c[0].Value.(bson.Doc)[0].Value = 10
+	c[1].Value.(bson.Map)["b"].([]interface{})[0] = 20
+	c[2].Value.([]interface{})[0].(bson.Doc)[0].Value = 30
+	c[3].Value.(bson.Binary).Data[0] = 40
+	c[4].Value.(*bson.Bson).Raw()[7] = 50
+	if !d.Equal(bson.Doc{
+		{"doc", bson.Doc{{"a", 1}}},
+		{"map", bson.Map{"b": []interface{}{2}}},
+		{"array", []interface{}{bson.Doc{{"c", 3}}}},
+		{"binary", bson.Binary{Subtype: bson.BinaryTypeGeneral, Data: []byte{1, 2}}},
+		{"bson", bson.Doc{{"d", 4}}.Bson()},
+	}) {
+		t.Errorf("the original doc is changed: %v", d)
+	}
+	if c.Equal(d) {
+		t.Errorf("expected different docs")
+	}
+
+	// typed slices, maps and pointers
+	type point struct {
+		X    int
+		Tags []string
+	}
+	n := 1
+	d = bson.Doc{
+		{"tags", []string{"a"}},
+		{"docs", []bson.Doc{{{"x", 1}}}},
+		{"map", map[string]interface{}{"y": []int{2}}},
+		{"ints", [2][]int{{3}, {4}}},
+		{"ptr", &n},
+		{"point", &point{X: 5, Tags: []string{"b"}}},
+		{"nil", []string(nil)},
+	}
+	c = d.Clone()
+	c[0].Value.([]string)[0] = "CHANGED"
+	c[1].Value.([]bson.Doc)[0][0].Value = 99
+	c[2].Value.(map[string]interface{})["y"].([]int)[0] = 20
+	c[2].Value.(map[string]interface{})["z"] = 21
+	c[3].Value.([2][]int)[1][0] = 40
+	*c[4].Value.(*int) = 50
+	c[5].Value.(*point).X = 60
+	c[5].Value.(*point).Tags[0] = "CHANGED"
+	if d[0].Value.([]string)[0] != "a" ||
+		d[1].Value.([]bson.Doc)[0][0].Value != 1 ||
+		d[2].Value.(map[string]interface{})["y"].([]int)[0] != 2 ||
+		len(d[2].Value.(map[string]interface{})) != 1 ||
+		d[3].Value.([2][]int)[1][0] != 4 ||
+		n != 1 ||
+		d[5].Value.(*point).X != 5 || d[5].Value.(*point).Tags[0] != "b" {
+		t.Errorf("the original doc is changed: %v", d)
+	}
+	if c[6].Value.([]string) != nil {
+		t.Errorf("expected nil slice: %v", c[6].Value)
+	}
+
+	var tests = []struct {
+		a, b  bson.Doc
+		equal bool
+	}{
+		{nil, bson.Doc{}, true},
+		{bson.Doc{{"a", 1}, {"b", 2}}, bson.Doc{{"b", 2}, {"a", 1}}, false},
+		{bson.Doc{{"a", int32(1)}}, bson.Doc{{"a", int64(1)}}, false},
+		{bson.Doc{{"a", bson.Doc{}}}, bson.Doc{{"a", bson.Map{}}}, false},
+		{bson.Doc{{"a", (*bson.Bson)(nil)}}, bson.Doc{{"a", (*bson.Bson)(nil)}}, true},
+	}
+	for i, test := range tests {
+		if test.a.Equal(test.b) != test.equal {
+			t.Errorf("case %d: expected %v", i, test.equal)
+		}
+	}
+}
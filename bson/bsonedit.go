@@ -0,0 +1,231 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The editing methods return a new Bson, the bytes of bson are not changed.
+// The paths are dotted, e.g. "a.b.0.c", where the numbers are the indexes of arrays.
+
+// Set sets the value of path, the element is replaced in place if it exists,
+// otherwise it is appended to its parent, and the missing parent documents are created.
+// An array element can only be appended at the index of the array length.
+func (bson *Bson) Set(path string, value interface{}) (*Bson, error) {
+	keys, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := splice(bson.raw, false, keys, 0, true, func(old []byte, key string) ([]byte, error) {
+		return encodeElement(key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Bson{raw: raw}, nil
+}
+
+// Unset removes the element of path, nothing is changed if it doesn't exist.
+// An array element is set to null instead, so that the indexes of the others are kept.
+func (bson *Bson) Unset(path string) (*Bson, error) {
+	keys, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := splice(bson.raw, false, keys, 0, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Bson{raw: raw}, nil
+}
+
+// Rename moves the value of path oldPath to newPath, replacing the value of newPath if any.
+// The element is renamed in place if both paths are in the same document, otherwise it is
+// removed and appended like Set. Nothing is changed if oldPath doesn't exist.
+func (bson *Bson) Rename(oldPath, newPath string) (*Bson, error) {
+	oldKeys, err := splitPath(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newKeys, err := splitPath(newPath)
+	if err != nil {
+		return nil, err
+	}
+	if oldPath == newPath {
+		return nil, fmt.Errorf("can't rename %q to itself", oldPath)
+	}
+	if strings.HasPrefix(newPath, oldPath+".") || strings.HasPrefix(oldPath, newPath+".") {
+		return nil, fmt.Errorf("can't rename %q to %q: one is the parent of the other", oldPath, newPath)
+	}
+
+	v, err := bson.Lookup(oldPath)
+	if errors.Is(err, ErrElementNotFound) {
+		return &Bson{raw: append([]byte{}, bson.raw...)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(oldKeys) == len(newKeys) && strings.Join(oldKeys[:len(oldKeys)-1], ".") == strings.Join(newKeys[:len(newKeys)-1], ".") {
+		if len(oldKeys) > 1 {
+			parent, err := bson.Lookup(strings.Join(oldKeys[:len(oldKeys)-1], "."))
+			if err != nil {
+				return nil, err
+			}
+			if parent.Type == BsonTypeArray {
+				return nil, fmt.Errorf("can't rename the element %q of array", oldPath)
+			}
+		}
+
+		// remove the old value of newPath, and then rename the element in place
+		removed, err := bson.Unset(newPath)
+		if err != nil {
+			return nil, err
+		}
+		newKey := newKeys[len(newKeys)-1]
+		raw, err := splice(removed.raw, false, oldKeys, 0, false, func(old []byte, key string) ([]byte, error) {
+			elem := make([]byte, 0, len(old)-len(key)+len(newKey))
+			elem = append(elem, old[0])
+			elem = append(elem, newKey...)
+			return append(elem, old[1+len(key):]...), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Bson{raw: raw}, nil
+	}
+
+	removed, err := bson.Unset(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	return removed.Set(newPath, v)
+}
+
+func splitPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return keys, nil
+}
+
+// encodeElement returns the bytes of the element of name and value.
+func encodeElement(name string, value interface{}) ([]byte, error) {
+	b := NewBsonBuilder().RecordErrors()
+	b.Append(name, value)
+	b.Finish()
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+	raw := b.Raw()
+	return raw[4 : len(raw)-1], nil
+}
+
+var emptyDocument = []byte{5, 0, 0, 0, 0}
+
+// splice returns a copy of the raw document or array where the element of keys[depth:] is replaced by
+// the result of f, which gets the bytes of the existing element and its key. The element is
+// removed if f is nil. If the element doesn't exist, it is appended and the missing parent
+// documents are created if create is true, otherwise the document is not changed.
+func splice(raw []byte, isArray bool, keys []string, depth int, create bool, f func(old []byte, key string) ([]byte, error)) ([]byte, error) {
+	key := keys[depth]
+	start, end, found := findElement(raw, key)
+	last := depth == len(keys)-1
+
+	var elem []byte
+	switch {
+	case !found && !create:
+		return append([]byte{}, raw...), nil
+	case !found && isArray && key != itoa(elementCount(raw)):
+		return nil, fmt.Errorf("can't set %q: index %s is out of the array", strings.Join(keys, "."), key)
+	case last && f == nil && isArray:
+		// keep the indexes of the other elements
+		elem = append([]byte{byte(BsonTypeNull)}, raw[start+1:start+1+len(key)+1]...)
+	case last && f == nil:
+		elem = nil
+	case last:
+		var old []byte
+		if found {
+			old = raw[start:end]
+		}
+		var err error
+		if elem, err = f(old, key); err != nil {
+			return nil, err
+		}
+	default:
+		// the element is the parent of the following keys
+		t := BsonTypeBson
+		child := emptyDocument
+		if found {
+			t = BsonType(raw[start])
+			if t != BsonTypeBson && t != BsonTypeArray {
+				return nil, fmt.Errorf("can't edit %q: bson value of type %v at %q is not a document or array",
+					strings.Join(keys, "."), t, strings.Join(keys[:depth+1], "."))
+			}
+			child = raw[start+1+len(key)+1 : end]
+		}
+		newChild, err := splice(child, t == BsonTypeArray, keys, depth+1, create, f)
+		if err != nil {
+			return nil, err
+		}
+		elem = make([]byte, 0, 1+len(key)+1+len(newChild))
+		elem = append(elem, byte(t))
+		elem = append(elem, key...)
+		elem = append(elem, 0)
+		elem = append(elem, newChild...)
+	}
+
+	if !found {
+		// before the eod
+		start, end = len(raw)-1, len(raw)-1
+	}
+	out := make([]byte, 0, len(raw)-(end-start)+len(elem))
+	out = append(out, raw[:start]...)
+	out = append(out, elem...)
+	out = append(out, raw[end:]...)
+	n := uint32(len(out))
+	out[0], out[1], out[2], out[3] = byte(n), byte(n>>8), byte(n>>16), byte(n>>24)
+	return out, nil
+}
+
+// findElement returns the offsets [start, end) of the element named key in the raw document.
+func findElement(raw []byte, key string) (start, end int, found bool) {
+	var it BsonIterator
+	it.init(raw)
+	for it.Next() {
+		if string(it.name()) == key {
+			return it.offset, it.offset + it.elementLen, true
+		}
+	}
+	return 0, 0, false
+}
+
+func elementCount(raw []byte) int {
+	var it BsonIterator
+	it.init(raw)
+	n := 0
+	for it.Next() {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,155 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func newEditBson() *bson.Bson {
+	return bson.Doc{
+		{"a", 1},
+		{"b", bson.Doc{{"c", "x"}, {"d", []interface{}{1, bson.Doc{{"e", true}}}}}},
+		{"f", "y"},
+	}.Bson()
+}
+
+const editBson = `{"a":1, "b":{"c":"x", "d":[1, {"e":true}]}, "f":"y"}`
+
+func checkEdit(t *testing.T, name string, b *bson.Bson, err error, expected string) {
+	if err != nil {
+		t.Errorf("%s: unexpected error: %v", name, err)
+		return
+	}
+	if err := b.Validate(); err != nil {
+		t.Errorf("%s: invalid bson: %v", name, err)
+	}
+	if b.String() != expected {
+		t.Errorf("%s: expected: %s\n  actual: %s", name, expected, b)
+	}
+}
+
+func TestBsonSet(t *testing.T) {
+	var tests = []struct {
+		path     string
+		value    interface{}
+		expected string
+	}{
+		{"a", "replaced", `{"a":"replaced", "b":{"c":"x", "d":[1, {"e":true}]}, "f":"y"}`},
+		{"b.c", bson.Doc{{"n", 1}}, `{"a":1, "b":{"c":{"n":1}, "d":[1, {"e":true}]}, "f":"y"}`},
+		{"b.d.1.e", false, `{"a":1, "b":{"c":"x", "d":[1, {"e":false}]}, "f":"y"}`},
+		{"b.d.2", 2.5, `{"a":1, "b":{"c":"x", "d":[1, {"e":true}, 2.5]}, "f":"y"}`},
+		{"g", nil, `{"a":1, "b":{"c":"x", "d":[1, {"e":true}]}, "f":"y", "g":null}`},
+		{"h.i.j", 3, `{"a":1, "b":{"c":"x", "d":[1, {"e":true}]}, "f":"y", "h":{"i":{"j":3}}}`},
+		{"b.d.1.k", "z", `{"a":1, "b":{"c":"x", "d":[1, {"e":true, "k":"z"}]}, "f":"y"}`},
+	}
+
+	for _, test := range tests {
+		orig := newEditBson()
+		b, err := orig.Set(test.path, test.value)
+		checkEdit(t, "set "+test.path, b, err, test.expected)
+		if orig.String() != editBson {
+			t.Errorf("set %s: the original bson is changed: %s", test.path, orig)
+		}
+	}
+
+	var errors = []struct {
+		path  string
+		value interface{}
+	}{
+		{"a.b", 1},     // into int32
+		{"b.d.5", 1},   // out of the array
+		{"", 1},        // invalid path
+		{"b..c", 1},    // invalid path
+		{"a", 1 + 2i},  // unsupported value
+		{"f.x.y", nil}, // into string
+	}
+	for _, test := range errors {
+		if _, err := newEditBson().Set(test.path, test.value); err == nil {
+			t.Errorf("set %q: expected error", test.path)
+		}
+	}
+}
+
+func TestBsonUnset(t *testing.T) {
+	var tests = []struct {
+		path     string
+		expected string
+	}{
+		{"a", `{"b":{"c":"x", "d":[1, {"e":true}]}, "f":"y"}`},
+		{"f", `{"a":1, "b":{"c":"x", "d":[1, {"e":true}]}}`},
+		{"b.c", `{"a":1, "b":{"d":[1, {"e":true}]}, "f":"y"}`},
+		{"b.d.0", `{"a":1, "b":{"c":"x", "d":[null, {"e":true}]}, "f":"y"}`},
+		{"b.d.1.e", `{"a":1, "b":{"c":"x", "d":[1, {}]}, "f":"y"}`},
+		{"x", editBson},
+		{"x.y", editBson},
+		{"b.d.9", editBson},
+	}
+
+	for _, test := range tests {
+		orig := newEditBson()
+		b, err := orig.Unset(test.path)
+		checkEdit(t, "unset "+test.path, b, err, test.expected)
+		if orig.String() != editBson {
+			t.Errorf("unset %s: the original bson is changed: %s", test.path, orig)
+		}
+	}
+
+	if _, err := newEditBson().Unset("a.b"); err == nil {
+		t.Errorf("expected error of unsetting in int32")
+	}
+}
+
+func TestBsonRename(t *testing.T) {
+	var tests = []struct {
+		old, new string
+		expected string
+	}{
+		{"a", "z", `{"z":1, "b":{"c":"x", "d":[1, {"e":true}]}, "f":"y"}`},
+		{"a", "f", `{"f":1, "b":{"c":"x", "d":[1, {"e":true}]}}`},
+		{"b.c", "b.cc", `{"a":1, "b":{"cc":"x", "d":[1, {"e":true}]}, "f":"y"}`},
+		{"b.d.1.e", "b.d.1.ee", `{"a":1, "b":{"c":"x", "d":[1, {"ee":true}]}, "f":"y"}`},
+		{"b.c", "c", `{"a":1, "b":{"d":[1, {"e":true}]}, "f":"y", "c":"x"}`},
+		{"a", "b.a", `{"b":{"c":"x", "d":[1, {"e":true}], "a":1}, "f":"y"}`},
+		{"x", "y", editBson},
+	}
+
+	for _, test := range tests {
+		orig := newEditBson()
+		b, err := orig.Rename(test.old, test.new)
+		checkEdit(t, "rename "+test.old, b, err, test.expected)
+		if orig.String() != editBson {
+			t.Errorf("rename %s: the original bson is changed: %s", test.old, orig)
+		}
+	}
+
+	var errors = []struct {
+		old, new string
+	}{
+		{"a", "a"},
+		{"b", "b.c"},
+		{"b.c", "b"},
+		{"b.d.0", "b.d.5"},
+		{"a.x", "y"},
+		{"", "a"},
+	}
+	for _, test := range errors {
+		if _, err := newEditBson().Rename(test.old, test.new); err == nil {
+			t.Errorf("rename %q to %q: expected error", test.old, test.new)
+		}
+	}
+}
@@ -28,6 +28,8 @@ type BsonIterator struct {
 	elementLen int
 	keyLen     int
 	value      []byte
+
+	decoder *decoder // used by struct decoding
 }
 
 func bytesToInt32(b []byte) int32 {
@@ -60,14 +62,15 @@ func NewBsonIterator(bson *Bson) *BsonIterator {
 		panic("null bson")
 	}
 
-	raw := bson.Raw()
-	length := int(bytesToInt32(raw))
+	it := &BsonIterator{}
+	it.init(bson.Raw())
+	return it
+}
 
-	return &BsonIterator{
-		raw:    raw,
-		length: length,
-		offset: 4,
-	}
+func (it *BsonIterator) init(raw []byte) {
+	it.raw = raw
+	it.length = int(bytesToInt32(raw))
+	it.Reset()
 }
 
 func (it *BsonIterator) Reset() {
@@ -120,45 +123,55 @@ func (it *BsonIterator) Next() bool {
 	it.value = it.raw[it.offset+fieldOffset:]
 
 	// calc value length
+	fieldOffset += valueLength(t, it.value)
+	it.elementLen = fieldOffset
+
+	return true
+}
+
+// valueLength returns the length of the bson value of type t at the beginning of b.
+func valueLength(t BsonType, b []byte) int {
 	switch t {
 	case BsonTypeFloat64:
-		fieldOffset += 8
+		return 8
 	case BsonTypeString:
-		fieldOffset += int(bytesToInt32(it.value)) + 4
+		return int(bytesToInt32(b)) + 4
 	case BsonTypeBson:
 		fallthrough
 	case BsonTypeArray:
-		fieldOffset += int(bytesToInt32(it.value))
+		return int(bytesToInt32(b))
 	case BsonTypeBinary:
-		fieldOffset += int(bytesToInt32(it.value)) + 5
+		return int(bytesToInt32(b)) + 5
 	case BsonTypeObjectId:
-		fieldOffset += 12
+		return 12
 	case BsonTypeBool:
-		fieldOffset += 1
+		return 1
 	case BsonTypeDate:
-		fieldOffset += 8
+		return 8
 	case BsonTypeNull:
 		// no value
+		return 0
 	case BsonTypeRegEx:
-		patternLen := cstringLength(it.value)
-		optionsLen := cstringLength(it.value[patternLen:])
-		fieldOffset += patternLen + optionsLen
+		patternLen := cstringLength(b)
+		optionsLen := cstringLength(b[patternLen:])
+		return patternLen + optionsLen
 	case BsonTypeInt32:
-		fieldOffset += 4
+		return 4
 	case BsonTypeTimestamp:
-		fieldOffset += 8
+		return 8
 	case BsonTypeInt64:
-		fieldOffset += 8
+		return 8
+	case BsonTypeDecimal:
+		return int(bytesToInt32(b))
 	case BsonTypeMaxKey:
 		// no value
+		return 0
 	case BsonTypeMinKey:
 		// no value
+		return 0
 	default:
 		panic(fmt.Sprintf("invalid bson type: %v", t))
 	}
-	it.elementLen = fieldOffset
-
-	return true
 }
 
 func (it *BsonIterator) BsonType() BsonType {
@@ -166,7 +179,16 @@ func (it *BsonIterator) BsonType() BsonType {
 }
 
 func (it *BsonIterator) Name() string {
-	return string(it.raw[it.offset+1 : it.offset+it.keyLen])
+	return string(it.name())
+}
+
+func (it *BsonIterator) name() []byte {
+	return it.raw[it.offset+1 : it.offset+it.keyLen]
+}
+
+// valueBytes returns the raw value of the current field.
+func (it *BsonIterator) valueBytes() []byte {
+	return it.value[:it.elementLen-1-it.keyLen]
 }
 
 func (it *BsonIterator) Value() interface{} {
@@ -197,6 +219,8 @@ func (it *BsonIterator) Value() interface{} {
 		return it.Timestamp()
 	case BsonTypeInt64:
 		return it.Int64()
+	case BsonTypeDecimal:
+		return it.Decimal()
 	case BsonTypeMaxKey:
 		return MaxKey
 	case BsonTypeMinKey:
@@ -216,8 +240,13 @@ func (it *BsonIterator) UTF8String() string {
 }
 
 func (it *BsonIterator) Bson() *Bson {
+	return &Bson{raw: it.document()}
+}
+
+// document returns the raw embedded document or array.
+func (it *BsonIterator) document() []byte {
 	len := bytesToInt32(it.value)
-	return &Bson{raw: it.value[:len]}
+	return it.value[:len]
 }
 
 func (it *BsonIterator) BsonArray() *BsonArray {
@@ -265,3 +294,7 @@ func (it *BsonIterator) Timestamp() Timestamp {
 func (it *BsonIterator) Int64() int64 {
 	return bytesToInt64(it.value)
 }
+
+func (it *BsonIterator) Decimal() Decimal {
+	return decodeDecimal(it.value)
+}
@@ -14,21 +14,196 @@
 
 package bson
 
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
 type Map map[string]interface{}
 
 func (m Map) toBsonBuilder(b *BsonBuilder) {
+	if b.unsortedKeys {
+		for name, v := range m {
+			b.Append(name, v)
+		}
+		return
+	}
+
+	if len(m) <= smallMapSize {
+		// the indexes are sorted instead of the elements to avoid the write barriers
+		var buf [smallMapSize]DocElement
+		var index [smallMapSize]uint8
+		elems := buf[:0]
+		for name, v := range m {
+			index[len(elems)] = uint8(len(elems))
+			elems = append(elems, DocElement{name, v})
+		}
+		sortIndex(elems, index[:len(elems)])
+		for _, i := range index[:len(elems)] {
+			b.Append(elems[i].Name, elems[i].Value)
+		}
+		return
+	}
+
+	elems := make(Doc, 0, len(m))
 	for name, v := range m {
-		b.Append(name, v)
+		elems = append(elems, DocElement{name, v})
+	}
+	sort.Sort(byName(elems))
+	for _, e := range elems {
+		b.Append(e.Name, e.Value)
+	}
+}
+
+func appendStringMap(b *BsonBuilder, m map[string]string) {
+	if b.unsortedKeys {
+		for name, v := range m {
+			b.AppendString(name, v)
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(m))
+	for name := range m {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		b.AppendString(name, m[name])
+	}
+}
+
+const smallMapSize = 16
+
+// sortIndex sorts the indexes of elems by name with insertion sort.
+func sortIndex(elems []DocElement, index []uint8) {
+	for i := 1; i < len(index); i++ {
+		for j := i; j > 0 && elems[index[j]].Name < elems[index[j-1]].Name; j-- {
+			index[j], index[j-1] = index[j-1], index[j]
+		}
+	}
+}
+
+type byName Doc
+
+func (d byName) Len() int           { return len(d) }
+func (d byName) Less(i, j int) bool { return d[i].Name < d[j].Name }
+func (d byName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// mapElements returns the elements of map m whose names are formatted by formatMapKey,
+// sorted by name unless b is set not to.
+func (b *BsonBuilder) mapElements(m reflect.Value) (Doc, error) {
+	elems := make(Doc, 0, m.Len())
+	for _, k := range m.MapKeys() {
+		name, err := formatMapKey(k)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, DocElement{name, m.MapIndex(k).Interface()})
+	}
+	if !b.unsortedKeys {
+		sort.Sort(byName(elems))
+	}
+	return elems, nil
+}
+
+// formatMapKey returns the name of map key k like encoding/json: strings are used directly,
+// encoding.TextMarshalers are marshaled, and integers and bools are formatted.
+func formatMapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if k.Kind() == reflect.Ptr && k.IsNil() {
+		return "", nil
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("can't marshal map key %v: %v", k.Interface(), err)
+		}
+		return string(text), nil
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(k.Bool()), nil
+	}
+	return "", fmt.Errorf("unsupported map key type %s", k.Type())
+}
+
+// parseMapKey returns the map key of type t parsed from name, the reverse of formatMapKey.
+func parseMapKey(name string, t reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(typeTextUnmarshaler) {
+		k := reflect.New(t)
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name)); err != nil {
+			return reflect.Value{}, fmt.Errorf("can't unmarshal map key %q into %s: %v", name, t, err)
+		}
+		return k.Elem(), nil
+	}
+	if t.Kind() == reflect.Ptr && t.Implements(typeTextUnmarshaler) {
+		k := reflect.New(t.Elem())
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name)); err != nil {
+			return reflect.Value{}, fmt.Errorf("can't unmarshal map key %q into %s: %v", name, t, err)
+		}
+		return k, nil
+	}
+
+	k := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		k.SetString(name)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(name, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("can't parse map key %q into %s: %v", name, t, err)
+		}
+		k.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(name, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("can't parse map key %q into %s: %v", name, t, err)
+		}
+		k.SetUint(u)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(name)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("can't parse map key %q into %s: %v", name, t, err)
+		}
+		k.SetBool(v)
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported map key type %s", t)
 	}
+	return k, nil
+}
+
+// mustParseMapKey is like parseMapKey but panics on error.
+func mustParseMapKey(name string, t reflect.Type) reflect.Value {
+	k, err := parseMapKey(name, t)
+	if err != nil {
+		panic(err)
+	}
+	return k
 }
 
 func (m Map) Bson() *Bson {
-	b := NewBsonBuilder()
+	b := NewBsonBuilderSize(mapSize(m))
 	m.toBsonBuilder(b)
 	b.Finish()
 	return b.Bson()
 }
 
+// BsonE is like Bson but returns the error instead of panicking.
+func (m Map) BsonE() (*Bson, error) {
+	return buildE(m.toBsonBuilder)
+}
+
 func (m Map) String() string {
 	return m.Bson().String()
 }
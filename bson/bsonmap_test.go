@@ -15,6 +15,9 @@
 package bson_test
 
 import (
+	"bytes"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/davidli2010/gobson_exp/bson"
@@ -51,6 +54,145 @@ func TestMap(t *testing.T) {
 	}
 }
 
+func TestMapSortedKeys(t *testing.T) {
+	type named map[string]int
+	type st struct {
+		A     int
+		Extra map[string]interface{} `bson:",inline"`
+	}
+
+	var tests = []struct {
+		value    interface{}
+		expected string
+	}{
+		{bson.Map{"c": 1, "a": 2, "b": 3}, `{"a":2, "b":3, "c":1}`},
+		{map[string]interface{}{"y": "1", "x": bson.Map{"2": 2, "1": 1}}, `{"x":{"1":1, "2":2}, "y":"1"}`},
+		{map[string]string{"b": "1", "a": "2", "": "3"}, `{"":"3", "a":"2", "b":"1"}`},
+		{named{"z": 1, "m": 2, "a": 3}, `{"a":3, "m":2, "z":1}`},
+		{st{A: 1, Extra: map[string]interface{}{"c": 1, "b": 2}}, `{"A":1, "b":2, "c":1}`},
+	}
+
+	for i, test := range tests {
+		first := bson.Doc{{"v", test.value}}.Bson()
+		expected := `{"v":` + test.expected + `}`
+		if first.String() != expected {
+			t.Errorf("case %d: expected %s, actual %s", i, expected, first)
+		}
+		for j := 0; j < 10; j++ {
+			if b := (bson.Doc{{"v", test.value}}).Bson(); !bytes.Equal(b.Raw(), first.Raw()) {
+				t.Errorf("case %d: expected the same bytes: %s, %s", i, first, b)
+				break
+			}
+		}
+	}
+}
+
+func TestLargeMapSortedKeys(t *testing.T) {
+	m := bson.Map{}
+	for i := 0; i < 40; i++ {
+		m[string(rune('z'-i%26))+string(rune('a'+i))] = i
+	}
+
+	prev := ""
+	it := m.Bson().Iterator()
+	for it.Next() {
+		if it.Name() <= prev {
+			t.Errorf("unsorted keys: %s, %s", prev, it.Name())
+		}
+		prev = it.Name()
+	}
+}
+
+type mapKey struct {
+	X, Y int
+}
+
+func (k mapKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", k.X, k.Y)), nil
+}
+
+func (k *mapKey) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d,%d", &k.X, &k.Y)
+	return err
+}
+
+func TestMapKeys(t *testing.T) {
+	type st struct {
+		Int    map[int]string
+		Int8   map[int8]int
+		Uint   map[uint64]string
+		Bool   map[bool]string
+		Text   map[mapKey]string
+		Nested map[int]map[uint16]bool
+	}
+
+	s := st{
+		Int:    map[int]string{1: "a", -20: "b"},
+		Int8:   map[int8]int{-128: 1},
+		Uint:   map[uint64]string{1 << 63: "big"},
+		Bool:   map[bool]string{true: "yes", false: "no"},
+		Text:   map[mapKey]string{{1, 2}: "p"},
+		Nested: map[int]map[uint16]bool{3: {4: true}},
+	}
+
+	b := bson.StructToBson(&s)
+	expected := `{"Int":{"-20":"b", "1":"a"}, "Int8":{"-128":1}, "Uint":{"9223372036854775808":"big"}, ` +
+		`"Bool":{"false":"no", "true":"yes"}, "Text":{"1,2":"p"}, "Nested":{"3":{"4":true}}}`
+	if b.String() != expected {
+		t.Errorf("expected: %s\n  actual: %s", expected, b)
+	}
+
+	var s2 st
+	b.Struct(&s2)
+	if !reflect.DeepEqual(s, s2) {
+		t.Errorf("expected: %v\n  actual: %v", s, s2)
+	}
+}
+
+func TestMapKeysError(t *testing.T) {
+	if _, err := (bson.Doc{{"m", map[float64]int{1.5: 1}}}).BsonE(); err == nil {
+		t.Errorf("expected error of float64 map key")
+	}
+
+	var tests = []struct {
+		doc bson.Doc
+		s   interface{}
+	}{
+		{bson.Doc{{"M", bson.Doc{{"abc", "x"}}}}, &struct{ M map[int]string }{}},
+		{bson.Doc{{"M", bson.Doc{{"256", "x"}}}}, &struct{ M map[uint8]string }{}},
+		{bson.Doc{{"M", bson.Doc{{"yes", "x"}}}}, &struct{ M map[bool]string }{}},
+		{bson.Doc{{"M", bson.Doc{{"1", "x"}}}}, &struct{ M map[float64]string }{}},
+	}
+
+	for i, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("case %d: expected panic of invalid map key", i)
+				}
+			}()
+			test.doc.Bson().Struct(test.s)
+		}()
+	}
+}
+
+func TestMapUnsortedKeys(t *testing.T) {
+	m := bson.Map{"c": 1, "a": 2, "b": map[string]string{"y": "1", "x": "2"}}
+	b := bson.NewBsonBuilder().SortMapKeys(false).AppendMap(m).Finish().Bson()
+
+	m2 := b.Map()
+	if len(m2) != len(m) || m2["a"] != int32(2) || m2["b"].(bson.Map)["x"] != "2" {
+		t.Errorf("unexpected result: %s", b)
+	}
+}
+
+func BenchmarkSdbBsonMapUnsorted(t *testing.B) {
+	for i := 0; i < t.N; i++ {
+		b := bson.NewBsonBuilder().SortMapKeys(false)
+		b.AppendMap(mdata2).Finish()
+	}
+}
+
 /*
 func TestMap2(t *testing.T) {
 	m := bson.Map{"int": int(12), "int8": int8(12), "int16": int16(12),
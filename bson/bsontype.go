@@ -14,7 +14,10 @@
 
 package bson
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type BsonType byte
 
@@ -38,10 +41,43 @@ const (
 	BsonTypeInt32
 	BsonTypeTimestamp
 	BsonTypeInt64
-	BsonTypeMaxKey BsonType = 0x7F
-	BsonTypeMinKey BsonType = 0xFF
+	BsonTypeDecimal BsonType = 0x64
+	BsonTypeMaxKey  BsonType = 0x7F
+	BsonTypeMinKey  BsonType = 0xFF
 )
 
+var bsonTypeNames = map[BsonType]string{
+	BsonTypeEOD:        "eod",
+	BsonTypeFloat64:    "float64",
+	BsonTypeString:     "string",
+	BsonTypeBson:       "document",
+	BsonTypeArray:      "array",
+	BsonTypeBinary:     "binary",
+	BsonTypeUndefined:  "undefined",
+	BsonTypeObjectId:   "objectid",
+	BsonTypeBool:       "bool",
+	BsonTypeDate:       "date",
+	BsonTypeNull:       "null",
+	BsonTypeRegEx:      "regex",
+	BsonTypeDBPointer:  "dbpointer",
+	BsonTypeCode:       "code",
+	BsonTypeSymbol:     "symbol",
+	BsonTypeCodeWScope: "codewscope",
+	BsonTypeInt32:      "int32",
+	BsonTypeTimestamp:  "timestamp",
+	BsonTypeInt64:      "int64",
+	BsonTypeDecimal:    "decimal",
+	BsonTypeMaxKey:     "maxkey",
+	BsonTypeMinKey:     "minkey",
+}
+
+func (t BsonType) String() string {
+	if name, ok := bsonTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("BsonType(%d)", byte(t))
+}
+
 type BinaryType byte
 
 const (
@@ -54,10 +90,21 @@ const (
 	BinaryTypeUser BinaryType = 0x80
 )
 
+// Date is the milliseconds since the Unix epoch.
 type Date int64
 
+// NewDate returns the Date of t, truncated to milliseconds.
+func NewDate(t time.Time) Date {
+	return Date(t.Unix()*1e3 + int64(t.Nanosecond()/1e6))
+}
+
+// Time returns the Date as a UTC time.Time.
+func (d Date) Time() time.Time {
+	return time.Unix(int64(d)/1e3, int64(d)%1e3*1e6).UTC()
+}
+
 func (d Date) String() string {
-	return fmt.Sprintf(`{"$date":%v}`, d)
+	return fmt.Sprintf(`{"$date":%d}`, int64(d))
 }
 
 type RegEx struct {
@@ -69,11 +116,18 @@ func (re RegEx) String() string {
 	return fmt.Sprintf(`{"$regex":"%s", "$options":"%s"}`, re.Pattern, re.Options)
 }
 
+// Timestamp is the seconds since the Unix epoch,
+// and the Increment which is microseconds in SequoiaDB.
 type Timestamp struct {
 	Second    int32
 	Increment int32
 }
 
+// Time returns the Timestamp as a UTC time.Time, Increment is taken as microseconds.
+func (t Timestamp) Time() time.Time {
+	return time.Unix(int64(t.Second), int64(t.Increment)*1e3).UTC()
+}
+
 func (t Timestamp) String() string {
 	return fmt.Sprintf(`{"$timestamp":"%d %d"}`, t.Second, t.Increment)
 }
@@ -16,6 +16,7 @@ package bson_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/davidli2010/gobson_exp/bson"
 )
@@ -75,3 +76,34 @@ func TestBinaryType(t *testing.T) {
 		}
 	}
 }
+
+func TestDateTime(t *testing.T) {
+	var tests = []struct {
+		time time.Time
+		date bson.Date
+	}{
+		{time.Unix(0, 0), 0},
+		{time.Date(2016, 1, 1, 0, 0, 1, 500999999, time.UTC), 1451606401500},
+		{time.Date(2016, 1, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600)), 1451606400000},
+		{time.Unix(-2, 500000000), -1500},
+	}
+
+	for _, test := range tests {
+		d := bson.NewDate(test.time)
+		if d != test.date {
+			t.Errorf("%v: expected %d, actual %d", test.time, test.date, d)
+		}
+		want := test.time.Truncate(time.Millisecond).UTC()
+		if tm := d.Time(); !tm.Equal(want) || tm.Location() != time.UTC {
+			t.Errorf("%d: expected %v, actual %v", d, want, tm)
+		}
+	}
+}
+
+func TestTimestampTime(t *testing.T) {
+	ts := bson.Timestamp{Second: 1451606410, Increment: 20}
+	want := time.Date(2016, 1, 1, 0, 0, 10, 20000, time.UTC)
+	if tm := ts.Time(); tm != want {
+		t.Errorf("%v: expected %v, actual %v", ts, want, tm)
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// The values are compared like SequoiaDB does: the types are ordered as
+//
+//	MinKey < null < numbers < string < document < array < binary < ObjectId < bool < Date < Timestamp < RegEx < MaxKey
+//
+// and int32, int64, float64 and Decimal are compared by their numeric values.
+// NaN is less than the other numbers and equal to itself.
+
+// typeOrder returns the canonical order of the bson type t.
+func typeOrder(t BsonType) int {
+	switch t {
+	case BsonTypeMinKey:
+		return -1
+	case BsonTypeEOD, BsonTypeUndefined:
+		return 0
+	case BsonTypeNull:
+		return 5
+	case BsonTypeFloat64, BsonTypeInt32, BsonTypeInt64, BsonTypeDecimal:
+		return 10
+	case BsonTypeString, BsonTypeSymbol:
+		return 15
+	case BsonTypeBson:
+		return 20
+	case BsonTypeArray:
+		return 25
+	case BsonTypeBinary:
+		return 30
+	case BsonTypeObjectId:
+		return 35
+	case BsonTypeBool:
+		return 40
+	case BsonTypeDate:
+		return 45
+	case BsonTypeTimestamp:
+		return 47
+	case BsonTypeRegEx:
+		return 50
+	case BsonTypeDBPointer:
+		return 55
+	case BsonTypeCode:
+		return 60
+	case BsonTypeCodeWScope:
+		return 65
+	case BsonTypeMaxKey:
+		return 127
+	default:
+		panic(fmt.Errorf("invalid bson type: %v", t))
+	}
+}
+
+// CompareValues compares the bson values a and b, it returns -1, 0 or +1 if a is
+// less than, equal to or greater than b. The values are any values that can be
+// appended to a BsonBuilder, it panics on the others.
+func CompareValues(a, b interface{}) int {
+	return rawValueOf(a).Compare(rawValueOf(b))
+}
+
+// rawValueOf returns the bson value of v, which is encoded if it isn't raw.
+func rawValueOf(v interface{}) RawValue {
+	switch v := v.(type) {
+	case RawValue:
+		return v
+	case *Bson:
+		return RawValue{Type: BsonTypeBson, Value: v.raw}
+	case *BsonArray:
+		return RawValue{Type: BsonTypeArray, Value: v.bson.raw}
+	}
+
+	elem, err := encodeElement("", v)
+	if err != nil {
+		panic(err)
+	}
+	// type, empty name and value
+	return RawValue{Type: BsonType(elem[0]), Value: elem[2:]}
+}
+
+// Compare compares v and o in the order of SequoiaDB, see CompareValues.
+func (v RawValue) Compare(o RawValue) int {
+	if c := compareInt(typeOrder(v.Type), typeOrder(o.Type)); c != 0 {
+		return c
+	}
+
+	switch v.Type {
+	case BsonTypeFloat64, BsonTypeInt32, BsonTypeInt64, BsonTypeDecimal:
+		return compareNumbers(v, o)
+	case BsonTypeString, BsonTypeSymbol:
+		return bytes.Compare(stringBytes(v.Value), stringBytes(o.Value))
+	case BsonTypeBson, BsonTypeArray:
+		return compareDocuments(v.Value, o.Value)
+	case BsonTypeBinary:
+		x, y := v.Binary(), o.Binary()
+		if c := compareInt(len(x.Data), len(y.Data)); c != 0 {
+			return c
+		}
+		if c := compareInt(int(x.Subtype), int(y.Subtype)); c != 0 {
+			return c
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case BsonTypeObjectId:
+		return bytes.Compare(v.Value[:12], o.Value[:12])
+	case BsonTypeBool:
+		return compareInt(int(v.Value[0]), int(o.Value[0]))
+	case BsonTypeDate:
+		return compareInt64(bytesToInt64(v.Value), bytesToInt64(o.Value))
+	case BsonTypeTimestamp:
+		// the seconds are the high 32 bits
+		x, y := uint64(bytesToInt64(v.Value)), uint64(bytesToInt64(o.Value))
+		if x == y {
+			return 0
+		} else if x < y {
+			return -1
+		}
+		return 1
+	case BsonTypeRegEx:
+		x, y := v.RegEx(), o.RegEx()
+		if x.Pattern != y.Pattern {
+			return compareString(x.Pattern, y.Pattern)
+		}
+		return compareString(x.Options, y.Options)
+	case BsonTypeDBPointer, BsonTypeCode, BsonTypeCodeWScope:
+		return bytes.Compare(v.Value, o.Value)
+	default:
+		// EOD, undefined, null, MinKey and MaxKey
+		return 0
+	}
+}
+
+// stringBytes returns the characters of the raw string value b.
+func stringBytes(b []byte) []byte {
+	return b[4 : 4+bytesToInt32(b)-1]
+}
+
+// compareDocuments compares the raw documents or arrays a and b element by element,
+// the elements are compared by their type orders, names and values.
+func compareDocuments(a, b []byte) int {
+	var x, y BsonIterator
+	x.init(a)
+	y.init(b)
+	for {
+		xn, yn := x.Next(), y.Next()
+		if !xn || !yn {
+			return compareBool(xn, yn)
+		}
+		if c := compareInt(typeOrder(x.BsonType()), typeOrder(y.BsonType())); c != 0 {
+			return c
+		}
+		if c := bytes.Compare(x.name(), y.name()); c != 0 {
+			return c
+		}
+		if c := x.RawValue().Compare(y.RawValue()); c != 0 {
+			return c
+		}
+	}
+}
+
+func compareNumbers(a, b RawValue) int {
+	if a.Type == BsonTypeDecimal || b.Type == BsonTypeDecimal {
+		return compareRats(a, b)
+	}
+
+	switch {
+	case a.Type == BsonTypeFloat64 && b.Type == BsonTypeFloat64:
+		return compareFloat64(a.Float64(), b.Float64())
+	case a.Type == BsonTypeFloat64:
+		return -compareIntFloat(integerValue(b), a.Float64())
+	case b.Type == BsonTypeFloat64:
+		return compareIntFloat(integerValue(a), b.Float64())
+	default:
+		return compareInt64(integerValue(a), integerValue(b))
+	}
+}
+
+func integerValue(v RawValue) int64 {
+	if v.Type == BsonTypeInt32 {
+		return int64(v.Int32())
+	}
+	return v.Int64()
+}
+
+func compareFloat64(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	}
+	// NaN
+	return compareBool(!math.IsNaN(a), !math.IsNaN(b))
+}
+
+// compareIntFloat compares i and f exactly, without converting i to float64.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 1
+	case f >= 1<<63:
+		return -1
+	case f < -1<<63:
+		return 1
+	}
+	t := math.Trunc(f)
+	if c := compareInt64(i, int64(t)); c != 0 {
+		return c
+	}
+	// i == t, compare by the fraction of f
+	return compareFloat64(t, f)
+}
+
+// The classes of numbers compared with decimals.
+const (
+	numberNaN = iota
+	numberMin
+	numberFinite
+	numberMax
+)
+
+// compareRats compares the numbers by their exact values, one of them is a decimal.
+func compareRats(a, b RawValue) int {
+	ca, x := numberRat(a)
+	cb, y := numberRat(b)
+	if ca != cb || ca != numberFinite {
+		return compareInt(ca, cb)
+	}
+	return x.Cmp(y)
+}
+
+// numberRat returns the class of the number v, and its value if it is finite.
+func numberRat(v RawValue) (int, *big.Rat) {
+	switch v.Type {
+	case BsonTypeFloat64:
+		f := v.Float64()
+		switch {
+		case math.IsNaN(f):
+			return numberNaN, nil
+		case math.IsInf(f, -1):
+			return numberMin, nil
+		case math.IsInf(f, 1):
+			return numberMax, nil
+		}
+		return numberFinite, new(big.Rat).SetFloat64(f)
+	case BsonTypeDecimal:
+		d := v.Decimal()
+		switch d.Value {
+		case "NaN":
+			return numberNaN, nil
+		case "MIN":
+			return numberMin, nil
+		case "MAX":
+			return numberMax, nil
+		}
+		r, ok := new(big.Rat).SetString(d.Value)
+		if !ok {
+			panic(fmt.Sprintf("invalid decimal: %s", d.Value))
+		}
+		return numberFinite, r
+	default:
+		return numberFinite, new(big.Rat).SetInt64(integerValue(v))
+	}
+}
+
+func compareInt(a, b int) int {
+	return compareInt64(int64(a), int64(b))
+}
+
+func compareInt64(a, b int64) int {
+	if a == b {
+		return 0
+	} else if a < b {
+		return -1
+	}
+	return 1
+}
+
+func compareString(a, b string) int {
+	if a == b {
+		return 0
+	} else if a < b {
+		return -1
+	}
+	return 1
+}
+
+// compareBool compares a and b with false < true.
+func compareBool(a, b bool) int {
+	if a == b {
+		return 0
+	} else if b {
+		return -1
+	}
+	return 1
+}
+
+// Compare compares the documents bson and other element by element, see CompareValues.
+func (bson *Bson) Compare(other *Bson) int {
+	return compareDocuments(bson.raw, other.raw)
+}
+
+// Equal reports whether bson and other are the same documents. If semantic is false,
+// they must have the same bytes; otherwise the values are compared like Compare,
+// e.g. {"a": 1} and {"a": 1.0} are equal. The order of the elements matters either way.
+func (bson *Bson) Equal(other *Bson, semantic bool) bool {
+	if !semantic {
+		return bytes.Equal(bson.raw, other.raw)
+	}
+	return bson.Compare(other) == 0
+}
+
+// OrderBy compares the documents by the fields of an order-by spec like SequoiaDB,
+// e.g. {"a": 1, "b.c": -1} sorts by a in ascending order then by b.c in descending order.
+// A missing field is less than any value.
+type OrderBy struct {
+	keys []orderByKey
+}
+
+type orderByKey struct {
+	path string
+	desc bool
+}
+
+// NewOrderBy returns the OrderBy of the spec, whose values must be non-zero numbers.
+func NewOrderBy(spec *Bson) (*OrderBy, error) {
+	o := &OrderBy{}
+	it := spec.Iterator()
+	for it.Next() {
+		v := it.RawValue()
+		if typeOrder(v.Type) != typeOrder(BsonTypeInt32) {
+			return nil, fmt.Errorf("invalid order of %q: %v", it.Name(), v)
+		}
+		c := compareNumbers(v, RawValue{Type: BsonTypeInt32, Value: []byte{0, 0, 0, 0}})
+		if c == 0 {
+			return nil, fmt.Errorf("invalid order of %q: %v", it.Name(), v)
+		}
+		o.keys = append(o.keys, orderByKey{path: it.Name(), desc: c < 0})
+	}
+	return o, nil
+}
+
+// Compare compares the documents a and b by the fields of o.
+func (o *OrderBy) Compare(a, b *Bson) int {
+	for _, key := range o.keys {
+		x, errx := a.Lookup(key.path)
+		y, erry := b.Lookup(key.path)
+		// a missing value is less than MinKey
+		c := compareBool(errx == nil, erry == nil)
+		if c == 0 && errx == nil {
+			c = x.Compare(y)
+		}
+		if c != 0 {
+			if key.desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// Sorter returns the sort.Interface of docs ordered by o.
+func (o *OrderBy) Sorter(docs []*Bson) sort.Interface {
+	return bsonSorter{docs: docs, orderBy: o}
+}
+
+// Sort sorts docs by o, the equal documents are kept in their original order.
+func (o *OrderBy) Sort(docs []*Bson) {
+	sort.Stable(o.Sorter(docs))
+}
+
+type bsonSorter struct {
+	docs    []*Bson
+	orderBy *OrderBy
+}
+
+func (s bsonSorter) Len() int           { return len(s.docs) }
+func (s bsonSorter) Swap(i, j int)      { s.docs[i], s.docs[j] = s.docs[j], s.docs[i] }
+func (s bsonSorter) Less(i, j int) bool { return s.orderBy.Compare(s.docs[i], s.docs[j]) < 0 }
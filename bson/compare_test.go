@@ -0,0 +1,204 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestCompareValues(t *testing.T) {
+	oid := bson.ObjectIdFromHex
+	id1, _ := oid("000000000000000000000001")
+	id2, _ := oid("000000000000000000000002")
+
+	// in ascending order, the values of a group are equal
+	var groups = [][]interface{}{
+		{bson.MinKey},
+		{nil},
+		{math.NaN(), bson.Decimal{Value: "NaN"}},
+		{bson.Decimal{Value: "MIN"}, math.Inf(-1)},
+		{int64(math.MinInt64)},
+		{-1.5, bson.Decimal{Value: "-1.50"}},
+		{int32(-1), int64(-1), -1.0, bson.Decimal{Value: "-1"}},
+		{0, 0.0, bson.Decimal{Value: "0.000"}},
+		{0.5},
+		{1, int64(1), 1.0, bson.Decimal{Value: "1"}},
+		{bson.Decimal{Value: "1.0000000000000000000001"}},
+		{int64(1<<53 + 1)},
+		{float64(1<<53 + 2)},
+		{int64(math.MaxInt64)},
+		{float64(1 << 63)},
+		{math.Inf(1), bson.Decimal{Value: "MAX"}},
+		{""},
+		{"a"},
+		{"ab"},
+		{"b"},
+		{bson.Doc{}},
+		{bson.Doc{{"a", 1}}, bson.Doc{{"a", 1.0}}},
+		{bson.Doc{{"a", 1}, {"b", 1}}},
+		{bson.Doc{{"b", 0}}},
+		{bson.Doc{{"a", "x"}}}, // the types are compared before the names
+		{[]interface{}{}},
+		{[]interface{}{1}, []interface{}{int64(1)}},
+		{[]interface{}{1, 2}},
+		{[]interface{}{2}},
+		{bson.Binary{Subtype: bson.BinaryTypeUser, Data: []byte("z")}},
+		{bson.Binary{Subtype: bson.BinaryTypeGeneral, Data: []byte("ab")}},
+		{bson.Binary{Subtype: bson.BinaryTypeUser, Data: []byte("ab")}},
+		{id1},
+		{id2},
+		{false},
+		{true},
+		{bson.Date(-1)},
+		{bson.Date(1)},
+		{bson.Timestamp{Second: 1, Increment: 2}},
+		{bson.Timestamp{Second: 2, Increment: 1}},
+		{bson.RegEx{Pattern: "a", Options: "i"}},
+		{bson.RegEx{Pattern: "b", Options: ""}},
+		{bson.MaxKey},
+	}
+
+	for i, g := range groups {
+		for j, h := range groups {
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			for _, a := range g {
+				for _, b := range h {
+					if c := bson.CompareValues(a, b); c != expected {
+						t.Errorf("compare %v (%T) with %v (%T): expected %d, actual %d", a, a, b, b, expected, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCompareRawValues(t *testing.T) {
+	b := bson.Doc{{"a", bson.Doc{{"x", 1}}}, {"b", []interface{}{1, "s"}}}.Bson()
+	if c := bson.CompareValues(b, bson.Doc{{"a", bson.Doc{{"x", 1.0}}}, {"b", []interface{}{1, "s"}}}); c != 0 {
+		t.Errorf("expected the bson equal to the doc, actual %d", c)
+	}
+
+	v, err := b.Lookup("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := bson.CompareValues(v, []interface{}{1.0, "s"}); c != 0 {
+		t.Errorf("expected the raw array equal to the slice, actual %d", c)
+	}
+	if c := v.Compare(bson.RawValue{Type: bson.BsonTypeNull}); c != 1 {
+		t.Errorf("expected the array greater than null, actual %d", c)
+	}
+}
+
+func TestBsonEqual(t *testing.T) {
+	a := bson.Doc{{"a", 1}, {"b", "x"}}.Bson()
+	b := bson.Doc{{"a", 1.0}, {"b", "x"}}.Bson()
+	c := bson.Doc{{"b", "x"}, {"a", 1}}.Bson()
+
+	if !a.Equal(bson.Doc{{"a", 1}, {"b", "x"}}.Bson(), false) {
+		t.Errorf("expected %s equal to itself", a)
+	}
+	if a.Equal(b, false) {
+		t.Errorf("expected %s not equal to %s by bytes", a, b)
+	}
+	if !a.Equal(b, true) {
+		t.Errorf("expected %s equal to %s semantically", a, b)
+	}
+	if a.Equal(c, true) {
+		t.Errorf("expected %s not equal to %s semantically", a, c)
+	}
+	if a.Compare(c) != -1 || c.Compare(a) != 1 {
+		t.Errorf("expected %s less than %s", a, c)
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	docs := []*bson.Bson{
+		bson.Doc{{"n", 0}, {"a", 2}, {"b", bson.Doc{{"c", "x"}}}}.Bson(),
+		bson.Doc{{"n", 1}, {"a", 1.5}, {"b", bson.Doc{{"c", "y"}}}}.Bson(),
+		bson.Doc{{"n", 2}, {"a", int64(2)}, {"b", bson.Doc{{"c", "z"}}}}.Bson(),
+		bson.Doc{{"n", 3}, {"b", bson.Doc{{"c", "x"}}}}.Bson(),
+		bson.Doc{{"n", 4}, {"a", nil}}.Bson(),
+		bson.Doc{{"n", 5}, {"a", 2.0}, {"b", bson.Doc{{"c", "z"}}}}.Bson(),
+	}
+
+	var tests = []struct {
+		spec     bson.Doc
+		expected []int32
+	}{
+		{bson.Doc{{"a", 1}}, []int32{3, 4, 1, 0, 2, 5}},
+		{bson.Doc{{"a", -1}}, []int32{0, 2, 5, 1, 4, 3}},
+		{bson.Doc{{"a", 1}, {"b.c", -1.0}}, []int32{3, 4, 1, 2, 5, 0}},
+		{bson.Doc{{"b.c", 1}, {"n", int64(-1)}}, []int32{4, 3, 0, 1, 5, 2}},
+		{bson.Doc{}, []int32{0, 1, 2, 3, 4, 5}},
+	}
+
+	for _, test := range tests {
+		o, err := bson.NewOrderBy(test.spec.Bson())
+		if err != nil {
+			t.Errorf("order by %v: %v", test.spec, err)
+			continue
+		}
+		sorted := append([]*bson.Bson{}, docs...)
+		o.Sort(sorted)
+		for i, d := range sorted {
+			v, _ := d.Lookup("n")
+			if v.Int32() != test.expected[i] {
+				t.Errorf("order by %v: expected %v, actual %d at %d", test.spec, test.expected, v.Int32(), i)
+				break
+			}
+		}
+	}
+
+	// a missing field is less than MinKey, and greater in descending order
+	bounds := []*bson.Bson{
+		bson.Doc{{"a", bson.MaxKey}}.Bson(),
+		bson.Doc{{"a", bson.MinKey}}.Bson(),
+		bson.Doc{{"b", 1}}.Bson(),
+		bson.Doc{{"a", nil}}.Bson(),
+	}
+	for _, test := range []struct {
+		order    int
+		expected string
+	}{
+		{1, `[{"b":1} {"a":{"$minKey":1}} {"a":null} {"a":{"$maxKey":1}}]`},
+		{-1, `[{"a":{"$maxKey":1}} {"a":null} {"a":{"$minKey":1}} {"b":1}]`},
+	} {
+		o, err := bson.NewOrderBy(bson.Doc{{"a", test.order}}.Bson())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := append([]*bson.Bson{}, bounds...)
+		o.Sort(sorted)
+		if actual := fmt.Sprint(sorted); actual != test.expected {
+			t.Errorf("order by a: %d: expected %s, actual %s", test.order, test.expected, actual)
+		}
+	}
+
+	for _, spec := range []bson.Doc{{{"a", 0}}, {{"a", "asc"}}, {{"a", 1}, {"b", true}}} {
+		if _, err := bson.NewOrderBy(spec.Bson()); err == nil {
+			t.Errorf("order by %v: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Decimal is a SequoiaDB high precision decimal number.
+// Value is the number in decimal notation, or one of "NaN", "MAX" and "MIN".
+// Precision and Scale are the optional type modifier, zero Precision means
+// the precision is not limited.
+type Decimal struct {
+	Value     string
+	Precision int
+	Scale     int
+}
+
+func (d Decimal) String() string {
+	if d.Precision == 0 {
+		return fmt.Sprintf(`{"$decimal":"%s"}`, d.Value)
+	}
+	return fmt.Sprintf(`{"$decimal":"%s", "$precision":[%d, %d]}`, d.Value, d.Precision, d.Scale)
+}
+
+// SequoiaDB stores a decimal as:
+//
+//	int32   total size in bytes, including this header
+//	int32   type modifier, (precision << 16 | scale) or -1
+//	uint16  sign (high 2 bits) and display scale (low 14 bits)
+//	int16   weight of the first digit
+//	int16[] digits in base 10000, most significant first
+const (
+	decimalDecDigits    = 4
+	decimalHeaderSize   = 12
+	decimalSignMask     = 0xC000
+	decimalPos          = 0x0000
+	decimalNeg          = 0x4000
+	decimalSpecialSign  = 0xC000
+	decimalDScaleMask   = 0x3FFF
+	decimalSpecialNaN   = 0x0000
+	decimalSpecialMin   = 0x0001
+	decimalSpecialMax   = 0x0002
+	decimalMaxPrecision = 1000
+)
+
+func (d Decimal) typemod() (int32, error) {
+	if d.Precision == 0 {
+		return -1, nil
+	}
+	if d.Precision < 1 || d.Precision > decimalMaxPrecision || d.Scale < 0 || d.Scale > d.Precision {
+		return 0, fmt.Errorf("invalid decimal precision: (%d, %d)", d.Precision, d.Scale)
+	}
+	return int32(d.Precision<<16 | d.Scale), nil
+}
+
+// encode returns the SequoiaDB binary form of d.
+func (d Decimal) encode() ([]byte, error) {
+	typemod, err := d.typemod()
+	if err != nil {
+		return nil, err
+	}
+
+	var scale uint16
+	var weight int
+	var digits []int
+
+	switch {
+	case strings.EqualFold(d.Value, "NaN"):
+		scale = decimalSpecialSign | decimalSpecialNaN
+	case strings.EqualFold(d.Value, "MIN"):
+		scale = decimalSpecialSign | decimalSpecialMin
+	case strings.EqualFold(d.Value, "MAX"):
+		scale = decimalSpecialSign | decimalSpecialMax
+	default:
+		var neg bool
+		var dscale int
+		neg, weight, dscale, digits, err = parseDecimal(d.Value)
+		if err != nil {
+			return nil, err
+		}
+		scale = uint16(dscale)
+		if neg && len(digits) > 0 {
+			scale |= decimalNeg
+		}
+	}
+
+	size := decimalHeaderSize + 2*len(digits)
+	b := make([]byte, 0, size)
+	b = append(b, byte(size), byte(size>>8), byte(size>>16), byte(size>>24))
+	b = append(b, byte(typemod), byte(typemod>>8), byte(typemod>>16), byte(typemod>>24))
+	b = append(b, byte(scale), byte(scale>>8))
+	b = append(b, byte(weight), byte(weight>>8))
+	for _, digit := range digits {
+		b = append(b, byte(digit), byte(digit>>8))
+	}
+	return b, nil
+}
+
+// parseDecimal splits s into base 10000 digits.
+func parseDecimal(s string) (neg bool, weight int, dscale int, digits []int, err error) {
+	str := s
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+
+	exp := 0
+	if i := strings.IndexAny(str, "eE"); i >= 0 {
+		exp, err = strconv.Atoi(str[i+1:])
+		if err != nil {
+			return false, 0, 0, nil, fmt.Errorf("invalid decimal: %q", s)
+		}
+		str = str[:i]
+	}
+
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+	if len(intPart)+len(fracPart) == 0 {
+		return false, 0, 0, nil, fmt.Errorf("invalid decimal: %q", s)
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return false, 0, 0, nil, fmt.Errorf("invalid decimal: %q", s)
+		}
+	}
+
+	// move the decimal point by the exponent
+	if exp > 0 {
+		if exp > decimalDScaleMask {
+			return false, 0, 0, nil, fmt.Errorf("decimal out of range: %q", s)
+		}
+		if exp <= len(fracPart) {
+			intPart, fracPart = intPart+fracPart[:exp], fracPart[exp:]
+		} else {
+			intPart, fracPart = intPart+fracPart+strings.Repeat("0", exp-len(fracPart)), ""
+		}
+	} else if exp < 0 {
+		exp = -exp
+		if exp > decimalDScaleMask {
+			return false, 0, 0, nil, fmt.Errorf("decimal out of range: %q", s)
+		}
+		if exp <= len(intPart) {
+			intPart, fracPart = intPart[:len(intPart)-exp], intPart[len(intPart)-exp:]+fracPart
+		} else {
+			intPart, fracPart = "", strings.Repeat("0", exp-len(intPart))+intPart+fracPart
+		}
+	}
+
+	dscale = len(fracPart)
+	if dscale > decimalDScaleMask {
+		return false, 0, 0, nil, fmt.Errorf("decimal out of range: %q", s)
+	}
+
+	intPart = strings.TrimLeft(intPart, "0")
+	if n := len(intPart) % decimalDecDigits; n != 0 {
+		intPart = strings.Repeat("0", decimalDecDigits-n) + intPart
+	}
+	if n := len(fracPart) % decimalDecDigits; n != 0 {
+		fracPart = fracPart + strings.Repeat("0", decimalDecDigits-n)
+	}
+
+	weight = len(intPart)/decimalDecDigits - 1
+	all := intPart + fracPart
+	for i := 0; i < len(all); i += decimalDecDigits {
+		digit := 0
+		for _, c := range all[i : i+decimalDecDigits] {
+			digit = digit*10 + int(c-'0')
+		}
+		digits = append(digits, digit)
+	}
+
+	for len(digits) > 0 && digits[0] == 0 {
+		digits = digits[1:]
+		weight--
+	}
+	for len(digits) > 0 && digits[len(digits)-1] == 0 {
+		digits = digits[:len(digits)-1]
+	}
+	if len(digits) == 0 {
+		neg = false
+		weight = 0
+	}
+
+	return neg, weight, dscale, digits, nil
+}
+
+// decodeDecimal converts the SequoiaDB binary form of a decimal to Decimal.
+func decodeDecimal(b []byte) Decimal {
+	size := int(bytesToInt32(b))
+	if size < decimalHeaderSize || size > len(b) {
+		panic("invalid decimal size")
+	}
+
+	var d Decimal
+	typemod := bytesToInt32(b[4:])
+	if typemod != -1 {
+		d.Precision = int(typemod >> 16)
+		d.Scale = int(typemod & 0xFFFF)
+	}
+
+	scale := uint16(b[8]) | uint16(b[9])<<8
+	weight := int(int16(uint16(b[10]) | uint16(b[11])<<8))
+
+	if scale&decimalSignMask == decimalSpecialSign {
+		switch scale & decimalDScaleMask {
+		case decimalSpecialMin:
+			d.Value = "MIN"
+		case decimalSpecialMax:
+			d.Value = "MAX"
+		default:
+			d.Value = "NaN"
+		}
+		return d
+	}
+
+	ndigits := (size - decimalHeaderSize) / 2
+	digit := func(i int) int {
+		if i < 0 || i >= ndigits {
+			return 0
+		}
+		off := decimalHeaderSize + 2*i
+		return int(int16(uint16(b[off]) | uint16(b[off+1])<<8))
+	}
+
+	buf := make([]byte, 0, 4*(ndigits+2))
+	if scale&decimalSignMask == decimalNeg {
+		buf = append(buf, '-')
+	}
+
+	if weight < 0 || ndigits == 0 {
+		buf = append(buf, '0')
+	} else {
+		buf = strconv.AppendInt(buf, int64(digit(0)), 10)
+		for i := 1; i <= weight; i++ {
+			buf = appendDecimalDigit(buf, digit(i))
+		}
+	}
+
+	dscale := int(scale & decimalDScaleMask)
+	if dscale > 0 {
+		buf = append(buf, '.')
+		start := len(buf)
+		for i := weight + 1; len(buf)-start < dscale; i++ {
+			buf = appendDecimalDigit(buf, digit(i))
+		}
+		buf = buf[:start+dscale]
+	}
+
+	d.Value = string(buf)
+	return d
+}
+
+func appendDecimalDigit(buf []byte, digit int) []byte {
+	return append(buf,
+		byte('0'+digit/1000%10), byte('0'+digit/100%10), byte('0'+digit/10%10), byte('0'+digit%10))
+}
@@ -0,0 +1,88 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestDecimal(t *testing.T) {
+	var tests = []struct {
+		value bson.Decimal
+		want  string
+		raw   []byte
+	}{
+		{bson.Decimal{Value: "0"}, "0", []byte("\x0C\x00\x00\x00\xFF\xFF\xFF\xFF\x00\x00\x00\x00")},
+		{bson.Decimal{Value: "-0.00"}, "0.00", nil},
+		{bson.Decimal{Value: "12345.678"}, "12345.678",
+			[]byte("\x12\x00\x00\x00\xFF\xFF\xFF\xFF\x03\x00\x01\x00\x01\x00\x29\x09\x7C\x1A")},
+		{bson.Decimal{Value: "-1.5", Precision: 10, Scale: 2}, "-1.5",
+			[]byte("\x10\x00\x00\x00\x02\x00\x0A\x00\x01\x40\x00\x00\x01\x00\x88\x13")},
+		{bson.Decimal{Value: "0.00005"}, "0.00005", nil},
+		{bson.Decimal{Value: "000100000000"}, "100000000", nil},
+		{bson.Decimal{Value: "1.5e3"}, "1500", nil},
+		{bson.Decimal{Value: "-25E-3"}, "-0.025", nil},
+		{bson.Decimal{Value: "+.5"}, "0.5", nil},
+		{bson.Decimal{Value: "nan"}, "NaN", nil},
+		{bson.Decimal{Value: "MAX"}, "MAX", nil},
+		{bson.Decimal{Value: "MIN"}, "MIN", nil},
+	}
+
+	for _, test := range tests {
+		b := bson.NewBsonBuilder().AppendDecimal("d", test.value).Finish().Bson()
+		it := b.Iterator()
+		if !it.Next() || it.BsonType() != bson.BsonTypeDecimal {
+			t.Fatalf("invalid decimal type")
+		}
+
+		d := it.Decimal()
+		if d.Value != test.want || d.Precision != test.value.Precision || d.Scale != test.value.Scale {
+			t.Errorf("%v: expected %s, actual %v", test.value, test.want, d)
+		}
+
+		if test.raw != nil && !bytes.Equal(b.Raw()[7:len(b.Raw())-1], test.raw) {
+			t.Errorf("%v: expected raw %v, actual %v", test.value, test.raw, b.Raw()[7:len(b.Raw())-1])
+		}
+
+		if err := b.Validate(); err != nil {
+			t.Errorf("%v: %v", test.value, err)
+		}
+	}
+}
+
+func TestInvalidDecimal(t *testing.T) {
+	var tests = []bson.Decimal{
+		{Value: ""},
+		{Value: "."},
+		{Value: "1.2.3"},
+		{Value: "12a"},
+		{Value: "1e"},
+		{Value: "1", Precision: 2, Scale: 3},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%v: expected panic", test)
+				}
+			}()
+			bson.NewBsonBuilder().AppendDecimal("d", test)
+		}()
+	}
+}
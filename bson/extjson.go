@@ -0,0 +1,815 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ExtJSONError is returned by UnmarshalExtJSON for malformed input.
+// Line and Column are 1-based, Column counts characters.
+type ExtJSONError struct {
+	Msg    string
+	Line   int
+	Column int
+}
+
+func (e *ExtJSONError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Line, e.Column)
+}
+
+// UnmarshalExtJSON converts a JSON object to Bson, keeping the order of keys.
+// Both canonical and relaxed MongoDB Extended JSON v2 are accepted, as well as
+// the SequoiaDB shell dialect such as {"$date":"2016-01-01"},
+// {"$timestamp":"2016-01-01-12.00.00.000000"} and {"$decimal":"1.5"}.
+// Dates and timestamps without time zone are taken as UTC.
+func UnmarshalExtJSON(data []byte) (*Bson, error) {
+	p := &extJSONParser{data: data}
+	p.skipSpace()
+	if !p.consume('{') {
+		return nil, p.unexpected("expected '{'")
+	}
+
+	b := NewBsonBuilder()
+	p.skipSpace()
+	if !p.consume('}') {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.parseValue(b, key); err != nil {
+			return nil, err
+		}
+		if err := p.parseMembers(b); err != nil {
+			return nil, err
+		}
+	}
+	b.Finish()
+
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return nil, p.unexpected("expected end of input")
+	}
+	return b.Bson(), nil
+}
+
+type extJSONParser struct {
+	data []byte
+	pos  int
+}
+
+// extJSONNumber is the text of a JSON number inside an Extended JSON wrapper.
+type extJSONNumber string
+
+// extJSONMember is a key/value pair of an Extended JSON wrapper object.
+// The value is one of string, extJSONNumber, bool, nil,
+// []extJSONMember and []interface{}.
+type extJSONMember struct {
+	key   string
+	value interface{}
+}
+
+func (p *extJSONParser) errorAt(pos int, format string, args ...interface{}) error {
+	line, lineStart := 1, 0
+	for i := 0; i < pos && i < len(p.data); i++ {
+		if p.data[i] == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+	if pos > len(p.data) {
+		pos = len(p.data)
+	}
+	return &ExtJSONError{
+		Msg:    fmt.Sprintf(format, args...),
+		Line:   line,
+		Column: utf8.RuneCount(p.data[lineStart:pos]) + 1,
+	}
+}
+
+func (p *extJSONParser) unexpected(expected string) error {
+	if p.pos >= len(p.data) {
+		return p.errorAt(p.pos, "unexpected end of input, %s", expected)
+	}
+	r, _ := utf8.DecodeRune(p.data[p.pos:])
+	return p.errorAt(p.pos, "unexpected %q, %s", r, expected)
+}
+
+func (p *extJSONParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *extJSONParser) consume(c byte) bool {
+	if p.pos < len(p.data) && p.data[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseMembers parses the remaining members of an object up to and including '}'.
+func (p *extJSONParser) parseMembers(b *BsonBuilder) error {
+	for {
+		p.skipSpace()
+		if p.consume('}') {
+			return nil
+		}
+		if !p.consume(',') {
+			return p.unexpected("expected ',' or '}'")
+		}
+		key, err := p.parseKey()
+		if err != nil {
+			return err
+		}
+		if err := p.parseValue(b, key); err != nil {
+			return err
+		}
+	}
+}
+
+// parseKey parses a member name and the following ':'.
+func (p *extJSONParser) parseKey() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	key, err := p.parseString()
+	if err != nil {
+		return "", err
+	}
+	if strings.IndexByte(key, 0x00) >= 0 {
+		return "", p.errorAt(start, "key %q contains null character", key)
+	}
+	p.skipSpace()
+	if !p.consume(':') {
+		return "", p.unexpected("expected ':'")
+	}
+	return key, nil
+}
+
+func (p *extJSONParser) parseValue(b *BsonBuilder, name string) error {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return p.unexpected("expected value")
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.parseObject(b, name)
+	case c == '[':
+		p.pos++
+		child := b.AppendArrayStart(name)
+		if err := p.parseElements(child); err != nil {
+			return err
+		}
+		child.Finish()
+		child.AppendArrayEnd()
+	case c == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return err
+		}
+		b.AppendString(name, s)
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		num, err := p.parseNumber()
+		if err != nil {
+			return err
+		}
+		if err := appendExtJSONNumber(b, name, num); err != nil {
+			return p.errorAt(start, "%v", err)
+		}
+	default:
+		v, err := p.parseLiteral()
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			b.AppendNull(name)
+		} else {
+			b.AppendBool(name, v.(bool))
+		}
+	}
+	return nil
+}
+
+// parseObject parses an object value, which is either an embedded document
+// or an Extended JSON wrapper of a single value.
+func (p *extJSONParser) parseObject(b *BsonBuilder, name string) error {
+	start := p.pos
+	p.pos++ // skip '{'
+	p.skipSpace()
+	if p.consume('}') {
+		b.AppendBsonStart(name).Finish().AppendBsonEnd()
+		return nil
+	}
+
+	key, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+
+	if isExtJSONKey(key) {
+		members, err := p.parseWrapper(key)
+		if err != nil {
+			return err
+		}
+		if err := appendExtJSON(b, name, members); err != nil {
+			return p.errorAt(start, "%v", err)
+		}
+		return nil
+	}
+
+	child := b.AppendBsonStart(name)
+	if err := p.parseValue(child, key); err != nil {
+		return err
+	}
+	if err := p.parseMembers(child); err != nil {
+		return err
+	}
+	child.Finish()
+	child.AppendBsonEnd()
+	return nil
+}
+
+func (p *extJSONParser) parseElements(a *BsonArrayBuilder) error {
+	p.skipSpace()
+	if p.consume(']') {
+		return nil
+	}
+	for {
+		if err := p.parseValue(&a.builder, itoa(a.index)); err != nil {
+			return err
+		}
+		a.index++
+		p.skipSpace()
+		if p.consume(']') {
+			return nil
+		}
+		if !p.consume(',') {
+			return p.unexpected("expected ',' or ']'")
+		}
+	}
+}
+
+// parseWrapper parses the rest of an Extended JSON wrapper object whose
+// first key has been parsed.
+func (p *extJSONParser) parseWrapper(key string) ([]extJSONMember, error) {
+	v, err := p.parseRaw()
+	if err != nil {
+		return nil, err
+	}
+	members := []extJSONMember{{key, v}}
+	for {
+		p.skipSpace()
+		if p.consume('}') {
+			return members, nil
+		}
+		if !p.consume(',') {
+			return nil, p.unexpected("expected ',' or '}'")
+		}
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.parseRaw()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, extJSONMember{key, v})
+	}
+}
+
+// parseRaw parses a value without converting it to bson.
+func (p *extJSONParser) parseRaw() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, p.unexpected("expected value")
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		p.pos++
+		p.skipSpace()
+		if p.consume('}') {
+			return []extJSONMember{}, nil
+		}
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseWrapper(key)
+	case c == '[':
+		p.pos++
+		a := []interface{}{}
+		p.skipSpace()
+		if p.consume(']') {
+			return a, nil
+		}
+		for {
+			v, err := p.parseRaw()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+			p.skipSpace()
+			if p.consume(']') {
+				return a, nil
+			}
+			if !p.consume(',') {
+				return nil, p.unexpected("expected ',' or ']'")
+			}
+		}
+	case c == '"':
+		return p.parseString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		num, err := p.parseNumber()
+		return extJSONNumber(num), err
+	default:
+		return p.parseLiteral()
+	}
+}
+
+var extJSONLiterals = []struct {
+	text  string
+	value interface{}
+}{{"true", true}, {"false", false}, {"null", nil}}
+
+func (p *extJSONParser) parseLiteral() (interface{}, error) {
+	rest := p.data[p.pos:]
+	for _, lit := range extJSONLiterals {
+		// compared in place, the rest of data is not copied
+		if len(rest) >= len(lit.text) && string(rest[:len(lit.text)]) == lit.text {
+			p.pos += len(lit.text)
+			return lit.value, nil
+		}
+	}
+	return nil, p.unexpected("expected value")
+}
+
+func (p *extJSONParser) parseNumber() (string, error) {
+	start := p.pos
+	p.consume('-')
+	if p.consume('0') {
+		// no leading zeros
+	} else if !p.consumeDigits() {
+		return "", p.unexpected("expected digit")
+	}
+	if p.consume('.') {
+		if !p.consumeDigits() {
+			return "", p.unexpected("expected digit")
+		}
+	}
+	if p.consume('e') || p.consume('E') {
+		if !p.consume('+') {
+			p.consume('-')
+		}
+		if !p.consumeDigits() {
+			return "", p.unexpected("expected digit")
+		}
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+func (p *extJSONParser) consumeDigits() bool {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *extJSONParser) parseString() (string, error) {
+	if !p.consume('"') {
+		return "", p.unexpected("expected string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '"' {
+			s := string(p.data[start:p.pos])
+			p.pos++
+			return s, nil
+		}
+		if c == '\\' {
+			break
+		}
+		if c < 0x20 {
+			return "", p.errorAt(p.pos, "invalid character %q in string", c)
+		}
+		p.pos++
+	}
+
+	// slow path for escaped strings
+	buf := append([]byte{}, p.data[start:p.pos]...)
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return string(buf), nil
+		case c < 0x20:
+			return "", p.errorAt(p.pos, "invalid character %q in string", c)
+		case c != '\\':
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+
+		escape := p.pos
+		p.pos++
+		if p.pos >= len(p.data) {
+			break
+		}
+		switch c := p.data[p.pos]; c {
+		case '"', '\\', '/':
+			buf = append(buf, c)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, ok := p.parseHex4(p.pos + 1)
+			if !ok {
+				return "", p.errorAt(escape, "invalid unicode escape")
+			}
+			p.pos += 4
+			if utf16.IsSurrogate(r) {
+				if r2, ok := p.parseHex4(p.pos + 3); ok && p.data[p.pos+1] == '\\' && p.data[p.pos+2] == 'u' {
+					if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+						r = dec
+						p.pos += 6
+					}
+				}
+			}
+			buf = append(buf, string(r)...)
+		default:
+			return "", p.errorAt(escape, "invalid escape '\\%c' in string", c)
+		}
+		p.pos++
+	}
+	return "", p.unexpected("expected '\"'")
+}
+
+func (p *extJSONParser) parseHex4(pos int) (rune, bool) {
+	if pos < 0 || pos+4 > len(p.data) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(p.data[pos:pos+4]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+func appendExtJSONNumber(b *BsonBuilder, name string, num string) error {
+	if !strings.ContainsAny(num, ".eE") {
+		if v, err := strconv.ParseInt(num, 10, 64); err == nil {
+			if v >= math.MinInt32 && v <= math.MaxInt32 {
+				b.AppendInt32(name, int32(v))
+			} else {
+				b.AppendInt64(name, v)
+			}
+			return nil
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", num)
+	}
+	b.AppendFloat64(name, v)
+	return nil
+}
+
+func isExtJSONKey(key string) bool {
+	switch key {
+	case "$oid", "$date", "$timestamp", "$decimal", "$numberDecimal",
+		"$numberInt", "$numberLong", "$numberDouble", "$binary",
+		"$regex", "$regularExpression", "$minKey", "$maxKey":
+		return true
+	}
+	return false
+}
+
+// appendExtJSON converts an Extended JSON wrapper to a bson value.
+func appendExtJSON(b *BsonBuilder, name string, members []extJSONMember) error {
+	key := members[0].key
+	value := members[0].value
+
+	// optional members of each wrapper
+	var optional []string
+	switch key {
+	case "$binary":
+		optional = []string{"$type"}
+	case "$regex":
+		optional = []string{"$options"}
+	case "$decimal":
+		optional = []string{"$precision"}
+	}
+	extra := map[string]interface{}{}
+	for _, m := range members[1:] {
+		found := false
+		for _, o := range optional {
+			if m.key == o {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid %s: unexpected key %q", key, m.key)
+		}
+		if _, exist := extra[m.key]; exist {
+			return fmt.Errorf("invalid %s: duplicate key %q", key, m.key)
+		}
+		extra[m.key] = m.value
+	}
+
+	switch key {
+	case "$oid":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid $oid: expected 24 hex characters")
+		}
+		id, err := ObjectIdFromHex(s)
+		if err != nil {
+			return fmt.Errorf("invalid $oid: %v", err)
+		}
+		b.AppendObjectId(name, id)
+	case "$date":
+		ms, err := extJSONDate(value)
+		if err != nil {
+			return fmt.Errorf("invalid $date: %v", err)
+		}
+		b.AppendDate(name, Date(ms))
+	case "$timestamp":
+		ts, err := extJSONTimestamp(value)
+		if err != nil {
+			return fmt.Errorf("invalid $timestamp: %v", err)
+		}
+		b.AppendTimestamp(name, ts)
+	case "$decimal", "$numberDecimal":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid %s: expected string", key)
+		}
+		d := Decimal{Value: s}
+		if v, exist := extra["$precision"]; exist {
+			a, ok := v.([]interface{})
+			if !ok || len(a) != 2 {
+				return fmt.Errorf("invalid $precision: expected [precision, scale]")
+			}
+			precision, err1 := extJSONInt(a[0], 32)
+			scale, err2 := extJSONInt(a[1], 32)
+			if err1 != nil || err2 != nil || precision == 0 {
+				return fmt.Errorf("invalid $precision: expected [precision, scale]")
+			}
+			d.Precision, d.Scale = int(precision), int(scale)
+		}
+		if _, err := d.encode(); err != nil {
+			return err
+		}
+		b.AppendDecimal(name, d)
+	case "$numberInt":
+		s, ok := value.(string)
+		v, err := strconv.ParseInt(s, 10, 32)
+		if !ok || err != nil {
+			return fmt.Errorf("invalid $numberInt: %v", value)
+		}
+		b.AppendInt32(name, int32(v))
+	case "$numberLong":
+		v, err := extJSONInt(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid $numberLong: %v", value)
+		}
+		b.AppendInt64(name, v)
+	case "$numberDouble":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid $numberDouble: expected string")
+		}
+		var v float64
+		switch s {
+		case "Infinity":
+			v = math.Inf(1)
+		case "-Infinity":
+			v = math.Inf(-1)
+		case "NaN":
+			v = math.NaN()
+		default:
+			var err error
+			if v, err = strconv.ParseFloat(s, 64); err != nil {
+				return fmt.Errorf("invalid $numberDouble: %q", s)
+			}
+		}
+		b.AppendFloat64(name, v)
+	case "$binary":
+		bin, err := extJSONBinary(value, extra)
+		if err != nil {
+			return fmt.Errorf("invalid $binary: %v", err)
+		}
+		b.AppendBinary(name, bin)
+	case "$regex":
+		pattern, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid $regex: expected string")
+		}
+		options, ok := extra["$options"].(string)
+		if _, exist := extra["$options"]; exist && !ok {
+			return fmt.Errorf("invalid $options: expected string")
+		}
+		b.AppendRegex(name, RegEx{Pattern: pattern, Options: options})
+	case "$regularExpression":
+		fields, ok := value.([]extJSONMember)
+		if !ok {
+			return fmt.Errorf("invalid $regularExpression: expected object")
+		}
+		var re RegEx
+		for _, f := range fields {
+			s, ok := f.value.(string)
+			switch {
+			case ok && f.key == "pattern":
+				re.Pattern = s
+			case ok && f.key == "options":
+				re.Options = s
+			default:
+				return fmt.Errorf("invalid $regularExpression: unexpected key %q", f.key)
+			}
+		}
+		b.AppendRegex(name, re)
+	case "$minKey", "$maxKey":
+		if n, ok := value.(extJSONNumber); !ok || n != "1" {
+			return fmt.Errorf("invalid %s: expected 1", key)
+		}
+		if key == "$minKey" {
+			b.AppendMinKey(name)
+		} else {
+			b.AppendMaxKey(name)
+		}
+	default:
+		return fmt.Errorf("unsupported Extended JSON type %s", key)
+	}
+	return nil
+}
+
+// extJSONInt converts a JSON number or a numeric string to integer.
+func extJSONInt(v interface{}, bitSize int) (int64, error) {
+	switch v := v.(type) {
+	case string:
+		return strconv.ParseInt(v, 10, bitSize)
+	case extJSONNumber:
+		return strconv.ParseInt(string(v), 10, bitSize)
+	}
+	return 0, fmt.Errorf("expected integer")
+}
+
+// extJSONDateLayouts are the accepted layouts of $date strings.
+var extJSONDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02-15.04.05.999999",
+	"2006-01-02",
+}
+
+// extJSONDate returns the milliseconds since epoch of a $date value.
+func extJSONDate(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case string:
+		for _, layout := range extJSONDateLayouts {
+			if t, err := time.ParseInLocation(layout, v, time.UTC); err == nil {
+				return t.Unix()*1000 + int64(t.Nanosecond()/1e6), nil
+			}
+		}
+		return 0, fmt.Errorf("unknown date format %q", v)
+	case extJSONNumber:
+		return strconv.ParseInt(string(v), 10, 64)
+	case []extJSONMember:
+		if len(v) == 1 && v[0].key == "$numberLong" {
+			return extJSONInt(v[0].value, 64)
+		}
+	}
+	return 0, fmt.Errorf("expected string, integer or $numberLong")
+}
+
+func extJSONTimestamp(v interface{}) (Timestamp, error) {
+	switch v := v.(type) {
+	case string:
+		t, err := time.ParseInLocation("2006-01-02-15.04.05.999999", v, time.UTC)
+		if err == nil {
+			return Timestamp{Second: int32(t.Unix()), Increment: int32(t.Nanosecond() / 1e3)}, nil
+		}
+		// the format of Timestamp.String()
+		var ts Timestamp
+		if n, _ := fmt.Sscanf(v, "%d %d", &ts.Second, &ts.Increment); n == 2 {
+			return ts, nil
+		}
+		return Timestamp{}, fmt.Errorf("unknown timestamp format %q", v)
+	case []extJSONMember:
+		var ts Timestamp
+		var hasT, hasI bool
+		for _, f := range v {
+			n, err := extJSONInt(f.value, 64)
+			if err != nil || n < 0 || n > math.MaxUint32 {
+				return Timestamp{}, fmt.Errorf("%q must be uint32", f.key)
+			}
+			switch f.key {
+			case "t":
+				ts.Second, hasT = int32(uint32(n)), true
+			case "i":
+				ts.Increment, hasI = int32(uint32(n)), true
+			default:
+				return Timestamp{}, fmt.Errorf("unexpected key %q", f.key)
+			}
+		}
+		if !hasT || !hasI {
+			return Timestamp{}, fmt.Errorf("expected {\"t\":<t>, \"i\":<i>}")
+		}
+		return ts, nil
+	}
+	return Timestamp{}, fmt.Errorf("expected string or object")
+}
+
+func extJSONBinary(v interface{}, extra map[string]interface{}) (Binary, error) {
+	var data string
+	var subtype int64
+	var err error
+	switch v := v.(type) {
+	case string:
+		// {"$binary":"<base64>", "$type":"<hex>"}
+		t, exist := extra["$type"]
+		if !exist {
+			return Binary{}, fmt.Errorf("missing $type")
+		}
+		data = v
+		s, ok := t.(string)
+		n, err := strconv.ParseUint(s, 16, 8)
+		if !ok || err != nil {
+			return Binary{}, fmt.Errorf("invalid $type: %v", t)
+		}
+		subtype = int64(n)
+	case []extJSONMember:
+		// {"$binary":{"base64":"<base64>", "subType":"<hex>"}}
+		if len(extra) != 0 {
+			return Binary{}, fmt.Errorf("unexpected $type")
+		}
+		var hasData, hasType bool
+		for _, f := range v {
+			s, ok := f.value.(string)
+			switch {
+			case ok && f.key == "base64":
+				data, hasData = s, true
+			case ok && f.key == "subType":
+				if subtype, err = strconv.ParseInt(s, 16, 64); err != nil {
+					return Binary{}, fmt.Errorf("invalid subType: %q", s)
+				}
+				hasType = true
+			default:
+				return Binary{}, fmt.Errorf("unexpected key %q", f.key)
+			}
+		}
+		if !hasData || !hasType {
+			return Binary{}, fmt.Errorf("expected {\"base64\":<data>, \"subType\":<type>}")
+		}
+	default:
+		return Binary{}, fmt.Errorf("expected string or object")
+	}
+
+	if subtype < 0 || subtype > 0xFF {
+		return Binary{}, fmt.Errorf("invalid subtype %d", subtype)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return Binary{}, fmt.Errorf("invalid base64 data")
+	}
+	return Binary{Subtype: BinaryType(subtype), Data: raw}, nil
+}
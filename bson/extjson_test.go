@@ -0,0 +1,184 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestUnmarshalExtJSON(t *testing.T) {
+	oid := bson.ObjectId("\x56\x7a\x3b\x2c\x1d\x0e\x9f\x8a\x7b\x6c\x5d\x4e")
+
+	var tests = []struct {
+		json string
+		want bson.Doc
+	}{
+		{`{}`, bson.Doc{}},
+		{`{"a":1, "b":-2.5, "c":"x", "d":true, "e":false, "f":null}`,
+			bson.Doc{{"a", int32(1)}, {"b", -2.5}, {"c", "x"}, {"d", true}, {"e", false}, {"f", nil}}},
+		{`{"z":1, "a":2, "m":3}`, bson.Doc{{"z", int32(1)}, {"a", int32(2)}, {"m", int32(3)}}},
+		{`{"big":5000000000, "huge":1.5e300, "exp":1E2}`,
+			bson.Doc{{"big", int64(5000000000)}, {"huge", 1.5e300}, {"exp", float64(100)}}},
+		{`{"s":"tab\there \"q\" é 😀 \/"}`, bson.Doc{{"s", "tab\there \"q\" é 😀 /"}}},
+		{`{"obj":{"inner":{"x":1}}, "arr":[1, [2, {"y":3}], []], "e":{}}`,
+			bson.Doc{
+				{"obj", bson.Doc{{"inner", bson.Doc{{"x", int32(1)}}}}},
+				{"arr", []interface{}{int32(1), []interface{}{int32(2), bson.Doc{{"y", int32(3)}}}, []interface{}{}}},
+				{"e", bson.Doc{}},
+			}},
+		{`{"id":{"$oid":"567a3b2c1d0e9f8a7b6c5d4e"}}`, bson.Doc{{"id", oid}}},
+		{`{"d":{"$date":"2016-01-01"}}`, bson.Doc{{"d", bson.Date(1451606400000)}}},
+		{`{"d":{"$date":"2016-01-01T00:00:01.5Z"}}`, bson.Doc{{"d", bson.Date(1451606401500)}}},
+		{`{"d":{"$date":"2016-01-01T08:00:00+08:00"}}`, bson.Doc{{"d", bson.Date(1451606400000)}}},
+		{`{"d":{"$date":{"$numberLong":"-1000"}}}`, bson.Doc{{"d", bson.Date(-1000)}}},
+		{`{"d":{"$date":1451606400000}}`, bson.Doc{{"d", bson.Date(1451606400000)}}},
+		{`{"t":{"$timestamp":"2016-01-01-00.00.10.000020"}}`,
+			bson.Doc{{"t", bson.Timestamp{Second: 1451606410, Increment: 20}}}},
+		{`{"t":{"$timestamp":{"t":10, "i":20}}}`, bson.Doc{{"t", bson.Timestamp{Second: 10, Increment: 20}}}},
+		{`{"t":{"$timestamp":"10 20"}}`, bson.Doc{{"t", bson.Timestamp{Second: 10, Increment: 20}}}},
+		{`{"n":{"$decimal":"123.45"}}`, bson.Doc{{"n", bson.Decimal{Value: "123.45"}}}},
+		{`{"n":{"$decimal":"1.5", "$precision":[10, 2]}}`,
+			bson.Doc{{"n", bson.Decimal{Value: "1.5", Precision: 10, Scale: 2}}}},
+		{`{"n":{"$numberDecimal":"-0.001"}}`, bson.Doc{{"n", bson.Decimal{Value: "-0.001"}}}},
+		{`{"i":{"$numberInt":"7"}, "l":{"$numberLong":"-5000000000"}, "f":{"$numberDouble":"-Infinity"}}`,
+			bson.Doc{{"i", int32(7)}, {"l", int64(-5000000000)}, {"f", math.Inf(-1)}}},
+		{`{"b":{"$binary":"aGVsbG8=", "$type":"80"}}`,
+			bson.Doc{{"b", bson.Binary{Subtype: bson.BinaryTypeUser, Data: []byte("hello")}}}},
+		{`{"b":{"$binary":"aGVsbG8=", "$type":"0a"}}`,
+			bson.Doc{{"b", bson.Binary{Subtype: 0x0a, Data: []byte("hello")}}}},
+		{`{"b":{"$binary":{"base64":"aGVsbG8=", "subType":"04"}}}`,
+			bson.Doc{{"b", bson.Binary{Subtype: bson.BinaryTypeUUID, Data: []byte("hello")}}}},
+		{`{"r":{"$regex":"^a", "$options":"i"}, "r2":{"$regularExpression":{"pattern":"b$", "options":"m"}}}`,
+			bson.Doc{{"r", bson.RegEx{Pattern: "^a", Options: "i"}}, {"r2", bson.RegEx{Pattern: "b$", Options: "m"}}}},
+		{`{"min":{"$minKey":1}, "max":{"$maxKey":1}}`, bson.Doc{{"min", bson.MinKey}, {"max", bson.MaxKey}}},
+		{`{"a":{"$gt":1, "$type":2}}`, bson.Doc{{"a", bson.Doc{{"$gt", int32(1)}, {"$type", int32(2)}}}}},
+		// the unsupported types are documents
+		{`{"c":{"$code":"f()"}, "u":{"$undefined":true}, "s":{"$symbol":"x"}}`,
+			bson.Doc{{"c", bson.Doc{{"$code", "f()"}}}, {"u", bson.Doc{{"$undefined", true}}}, {"s", bson.Doc{{"$symbol", "x"}}}}},
+		{`{"p":{"$dbPointer":{"$ref":"c", "$id":{"$oid":"567a3b2c1d0e9f8a7b6c5d4e"}}}}`,
+			bson.Doc{{"p", bson.Doc{{"$dbPointer", bson.Doc{{"$ref", "c"}, {"$id", oid}}}}}}},
+	}
+
+	for _, test := range tests {
+		b, err := bson.UnmarshalExtJSON([]byte(test.json))
+		if err != nil {
+			t.Errorf("%s: %v", test.json, err)
+			continue
+		}
+		want := test.want.Bson()
+		if !bytes.Equal(b.Raw(), want.Raw()) {
+			t.Errorf("%s\nexpected: %s\n  actual: %s", test.json, want, b)
+		}
+	}
+}
+
+func TestUnmarshalExtJSONRoundTrip(t *testing.T) {
+	b := bson.Doc{
+		{"int", 1},
+		{"long", int64(5000000000)},
+		{"string", "hello"},
+		{"obj", bson.Doc{{"a", bson.NewObjectId()}, {"b", bson.Date(12345)}}},
+		{"array", []interface{}{1.5, true, nil, bson.Timestamp{Second: 1, Increment: 2}}},
+		{"regex", bson.RegEx{Pattern: "p", Options: "i"}},
+		{"decimal", bson.Decimal{Value: "-12345678901234567890.000123"}},
+		{"min", bson.MinKey},
+	}.Bson()
+
+	b2, err := bson.UnmarshalExtJSON([]byte(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Raw(), b2.Raw()) {
+		t.Errorf("expected: %s\n  actual: %s", b, b2)
+	}
+}
+
+func TestUnmarshalExtJSONError(t *testing.T) {
+	var tests = []struct {
+		json   string
+		line   int
+		column int
+	}{
+		{``, 1, 1},
+		{`[1]`, 1, 1},
+		{`{"a":1,}`, 1, 8},
+		{"{\n  \"a\": 1\n  \"b\": 2\n}", 3, 3},
+		{"{\"a\":\n\t[1, 2,, 3]}", 2, 8},
+		{`{"a":tru}`, 1, 6},
+		{`{"a":01}`, 1, 7},
+		{`{"a":"\q"}`, 1, 7},
+		{`{"a":"é\x"}`, 1, 8},
+		{`{"a":1} x`, 1, 9},
+		{`{"a":1e400}`, 1, 6},
+		{`{"a":"unterminated}`, 1, 20},
+		{`{"id":{"$oid":"xyz"}}`, 1, 7},
+		{`{"d":{"$date":"yesterday"}}`, 1, 6},
+		{`{"n":{"$decimal":"1.2.3"}}`, 1, 6},
+		{`{"x":{"$oid":"567a3b2c1d0e9f8a7b6c5d4e", "y":1}}`, 1, 6},
+		{`{"a\u0000b":1}`, 1, 2},
+		{`{"b":{"$binary":"aGVsbG8=", "$type":"128"}}`, 1, 6},
+		{`{"b":{"$binary":"aGVsbG8=", "$type":128}}`, 1, 6},
+	}
+
+	for _, test := range tests {
+		_, err := bson.UnmarshalExtJSON([]byte(test.json))
+		if err == nil {
+			t.Errorf("%q: expected error", test.json)
+			continue
+		}
+		e, ok := err.(*bson.ExtJSONError)
+		if !ok {
+			t.Errorf("%q: unexpected error type %T", test.json, err)
+			continue
+		}
+		if e.Line != test.line || e.Column != test.column {
+			t.Errorf("%q: expected line %d, column %d, actual: %v", test.json, test.line, test.column, err)
+		}
+	}
+}
+
+func extJSONArray(n int, elem string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"a":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(elem)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+func benchmarkUnmarshalExtJSON(t *testing.B, data []byte) {
+	t.SetBytes(int64(len(data)))
+	for i := 0; i < t.N; i++ {
+		if _, err := bson.UnmarshalExtJSON(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSdbBsonExtJSONLiterals(t *testing.B) {
+	benchmarkUnmarshalExtJSON(t, extJSONArray(40000, "null"))
+}
+
+func BenchmarkSdbBsonExtJSONNumbers(t *testing.B) {
+	benchmarkUnmarshalExtJSON(t, extJSONArray(40000, "1234"))
+}
@@ -0,0 +1,135 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+// Marshaler is the interface implemented by types that can marshal themselves
+// into a bson value. The returned bytes are the value part of a bson element,
+// e.g. the int32 length, the characters and the trailing 0x00 of a string.
+type Marshaler interface {
+	MarshalBSONValue() (BsonType, []byte, error)
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal a bson
+// value of themselves. data is the value part of a bson element and is only
+// valid during the call, it must be copied to be retained.
+type Unmarshaler interface {
+	UnmarshalBSONValue(t BsonType, data []byte) error
+}
+
+var (
+	typeMarshaler       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	typeUnmarshaler     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	typeTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isNilPointer reports whether v is a nil pointer stored in an interface.
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func (b *BsonBuilder) appendMarshaler(name string, m Marshaler) {
+	if isNilPointer(m) {
+		b.AppendNull(name)
+		return
+	}
+
+	t, data, err := m.MarshalBSONValue()
+	if err == nil {
+		err = checkValue(t, data)
+	}
+	if err != nil {
+		b.fail(fmt.Errorf("can't marshal %T to bson: %v", m, err))
+		return
+	}
+	b.appendRaw(name, RawValue{Type: t, Value: data})
+}
+
+func (b *BsonBuilder) appendTextMarshaler(name string, m encoding.TextMarshaler) {
+	if isNilPointer(m) {
+		b.AppendNull(name)
+		return
+	}
+
+	text, err := m.MarshalText()
+	if err != nil {
+		b.fail(fmt.Errorf("can't marshal %T to bson: %v", m, err))
+		return
+	}
+	b.AppendString(name, string(text))
+}
+
+// checkValue checks that data is exactly one bson value of type t.
+func checkValue(t BsonType, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid bson value: %v", r)
+		}
+	}()
+
+	if t == BsonTypeEOD {
+		return fmt.Errorf("invalid bson type: %v", t)
+	}
+	if n := valueLength(t, data); n != len(data) {
+		return fmt.Errorf("invalid length of bson value: %d, expected: %d", len(data), n)
+	}
+	return nil
+}
+
+// unmarshalValue calls the Unmarshaler or encoding.TextUnmarshaler of f if any,
+// and reports whether it is called.
+func unmarshalValue(f reflect.Value, it *BsonIterator) bool {
+	if !f.CanAddr() || !f.Addr().CanInterface() {
+		return false
+	}
+
+	switch u := f.Addr().Interface().(type) {
+	case Unmarshaler:
+		if err := u.UnmarshalBSONValue(it.BsonType(), it.valueBytes()); err != nil {
+			it.decoder.unmarshalError(f, it, err)
+		}
+		return true
+	case encoding.TextUnmarshaler:
+		if it.BsonType() != BsonTypeString {
+			return false
+		}
+		if err := u.UnmarshalText([]byte(it.UTF8String())); err != nil {
+			it.decoder.unmarshalError(f, it, err)
+		}
+		return true
+	}
+	return false
+}
+
+// implements reports whether values of type t, or pointers to them, implement iface.
+func implements(t reflect.Type, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+// encodeMarshaler encodes struct fields whose pointers implement Marshaler or
+// encoding.TextMarshaler. The methods are called if the field is addressable.
+func encodeMarshaler(b *BsonBuilder, name string, v reflect.Value) {
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+	b.Append(name, v.Interface())
+}
@@ -0,0 +1,225 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+// money is stored as int64 cents
+type money float64
+
+func (m money) MarshalBSONValue() (bson.BsonType, []byte, error) {
+	if m < 0 {
+		return bson.BsonTypeEOD, nil, errors.New("negative money")
+	}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(m*100+0.5))
+	return bson.BsonTypeInt64, data, nil
+}
+
+func (m *money) UnmarshalBSONValue(t bson.BsonType, data []byte) error {
+	if t != bson.BsonTypeInt64 {
+		return fmt.Errorf("unexpected bson type %v", t)
+	}
+	*m = money(int64(binary.LittleEndian.Uint64(data))) / 100
+	return nil
+}
+
+type level int
+
+var levelNames = []string{"low", "middle", "high"}
+
+func (l level) MarshalText() ([]byte, error) {
+	if l < 0 || int(l) >= len(levelNames) {
+		return nil, fmt.Errorf("invalid level %d", int(l))
+	}
+	return []byte(levelNames[l]), nil
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	for i, name := range levelNames {
+		if name == string(text) {
+			*l = level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid level %q", text)
+}
+
+// point has pointer receivers only
+type point struct {
+	X, Y int
+}
+
+func (p *point) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+func (p *point) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d,%d", &p.X, &p.Y)
+	return err
+}
+
+type badLength struct{}
+
+func (badLength) MarshalBSONValue() (bson.BsonType, []byte, error) {
+	return bson.BsonTypeInt32, []byte{1, 2}, nil
+}
+
+func TestAppendMarshaler(t *testing.T) {
+	var nilMoney *money
+	var tests = []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{money(50000000.12), int64(5000000012)},
+		{level(2), "high"},
+		{&point{1, 2}, "1,2"},
+		{nilMoney, nil},
+	}
+
+	for _, test := range tests {
+		b := bson.Doc{{"v", test.value}}.Bson()
+		want := bson.Doc{{"v", test.want}}.Bson()
+		if !bytes.Equal(b.Raw(), want.Raw()) {
+			t.Errorf("%#v: expected %s, actual %s", test.value, want, b)
+		}
+	}
+}
+
+func TestAppendMarshalerError(t *testing.T) {
+	var tests = []interface{}{
+		money(-1),
+		level(5),
+		badLength{},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%#v: expected panic", test)
+				}
+			}()
+			bson.NewBsonBuilder().Append("v", test)
+		}()
+	}
+}
+
+type marshalStruct struct {
+	Price  money
+	Prices []money
+	Level  level
+	Levels map[string]level
+	Point  point
+	PointP *point
+}
+
+func TestMarshalerStruct(t *testing.T) {
+	s := marshalStruct{
+		Price:  9.99,
+		Prices: []money{1, 2.5},
+		Level:  1,
+		Levels: map[string]level{"a": 2},
+		Point:  point{3, 4},
+		PointP: &point{5, 6},
+	}
+
+	b := bson.StructToBson(&s)
+	want := bson.NewBsonBuilder().
+		AppendInt64("Price", 999).
+		AppendArrayStart("Prices").
+		AppendInt64(100).
+		AppendInt64(250).
+		Finish().
+		AppendArrayEnd().
+		AppendString("Level", "middle").
+		AppendBsonStart("Levels").
+		AppendString("a", "high").
+		Finish().
+		AppendBsonEnd().
+		AppendString("Point", "3,4").
+		AppendString("PointP", "5,6").
+		Finish().
+		Bson()
+	if !bytes.Equal(b.Raw(), want.Raw()) {
+		t.Errorf("expected: %s\n  actual: %s", want, b)
+	}
+
+	var s2 marshalStruct
+	b.Struct(&s2)
+	if !reflect.DeepEqual(s, s2) {
+		t.Errorf("expected: %v\n  actual: %v", s, s2)
+	}
+}
+
+func TestUnmarshalerError(t *testing.T) {
+	var tests = []struct {
+		doc  bson.Doc
+		path string
+	}{
+		{bson.Doc{{"Price", "9.99"}}, "Price"},
+		{bson.Doc{{"Level", "unknown"}}, "Level"},
+		{bson.Doc{{"Levels", bson.Doc{{"a", "unknown"}}}}, "Levels.a"},
+	}
+
+	for _, test := range tests {
+		// skipped like the mismatched values
+		s := marshalStruct{Level: 1}
+		test.doc.Bson().Struct(&s)
+		if s.Price != 0 || s.Level != 1 || s.Levels["a"] != 0 {
+			t.Errorf("%v: unexpected struct: %v", test.doc, s)
+		}
+
+		err := test.doc.Bson().StructE(&s, nil)
+		if e, ok := err.(*bson.DecodeError); !ok || e.Path != test.path {
+			t.Errorf("%v: expected error of field %q, actual: %v", test.doc, test.path, err)
+		}
+	}
+}
+
+func TestTextUnmarshalerError(t *testing.T) {
+	type textStruct struct {
+		Id   bson.ObjectId `bson:"_id"`
+		Time time.Time
+		N    int
+	}
+	var tests = []bson.Doc{
+		{{"_id", "abc"}, {"N", 1}},
+		{{"Time", "2016-01-01"}, {"N", 1}},
+	}
+
+	for _, test := range tests {
+		var s textStruct
+		test.Bson().Struct(&s)
+		if !reflect.DeepEqual(s, textStruct{N: 1}) {
+			t.Errorf("%v: unexpected struct: %v", test, s)
+		}
+
+		err := test.Bson().StructE(&s, nil)
+		if e, ok := err.(*bson.DecodeError); !ok || e.Path != test[0].Name {
+			t.Errorf("%v: expected error of field %q, actual: %v", test, test[0].Name, err)
+		}
+	}
+}
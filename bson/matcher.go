@@ -0,0 +1,622 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matcher matches documents against a SequoiaDB condition, e.g.
+//
+//	{"a": 1, "b.c": {"$gt": 1, "$lt": 10}, "$or": [{"d": {"$exists": 1}}, {"e": {"$in": [1, 2]}}]}
+//
+// The fields of a path are looked up in the elements of arrays too, and a field
+// matches if any of its values matches, e.g. {"a": 1} matches {"a": [1, 2]}.
+// The values of different types are not ordered, e.g. {"a": {"$gt": 1}} doesn't match {"a": "x"}.
+type Matcher struct {
+	cond *Bson
+	root matchNode
+}
+
+// CompileMatcher compiles the condition cond, an empty condition matches any document.
+func CompileMatcher(cond Doc) (*Matcher, error) {
+	b, err := cond.BsonE()
+	if err != nil {
+		return nil, err
+	}
+	root, err := compileCondition(b.raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{cond: b, root: root}, nil
+}
+
+// Match reports whether the document b matches the condition, b isn't decoded.
+func (m *Matcher) Match(b *Bson) bool {
+	return m.root.match(b.raw)
+}
+
+func (m *Matcher) String() string {
+	return m.cond.String()
+}
+
+// matchNode matches raw documents.
+type matchNode interface {
+	match(raw []byte) bool
+}
+
+type andNode []matchNode
+
+func (n andNode) match(raw []byte) bool {
+	for _, c := range n {
+		if !c.match(raw) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []matchNode
+
+func (n orNode) match(raw []byte) bool {
+	for _, c := range n {
+		if c.match(raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// notNode matches if not all of its conditions match.
+type notNode []matchNode
+
+func (n notNode) match(raw []byte) bool {
+	return !andNode(n).match(raw)
+}
+
+// fieldNode matches the values of path with all of ops.
+type fieldNode struct {
+	path []string
+	ops  []valueMatcher
+}
+
+func (n *fieldNode) match(raw []byte) bool {
+	var buf [4]RawValue
+	values := appendPathValues(buf[:0], RawValue{Type: BsonTypeBson, Value: raw}, n.path)
+	return matchAll(n.ops, values)
+}
+
+func matchAll(ops []valueMatcher, values []RawValue) bool {
+	for _, op := range ops {
+		if !op.match(values) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendPathValues appends the values of the path keys in v. The keys are looked up in
+// the documents of arrays too, and the numeric keys are also the indexes of arrays.
+func appendPathValues(values []RawValue, v RawValue, keys []string) []RawValue {
+	if len(keys) == 0 {
+		return append(values, v)
+	}
+
+	switch v.Type {
+	case BsonTypeBson:
+		if e, found := lookupKey(v.Value, keys[0]); found {
+			values = appendPathValues(values, e, keys[1:])
+		}
+	case BsonTypeArray:
+		if e, found := lookupKey(v.Value, keys[0]); found {
+			values = appendPathValues(values, e, keys[1:])
+		}
+		var it BsonIterator
+		it.init(v.Value)
+		for it.Next() {
+			if it.BsonType() == BsonTypeBson {
+				values = appendPathValues(values, it.RawValue(), keys)
+			}
+		}
+	}
+	return values
+}
+
+// compileCondition compiles the raw condition document.
+func compileCondition(raw []byte) (matchNode, error) {
+	var nodes andNode
+	var it BsonIterator
+	it.init(raw)
+	for it.Next() {
+		name := it.Name()
+		v := it.RawValue()
+
+		var node matchNode
+		var err error
+		switch name {
+		case "$and", "$or", "$not":
+			node, err = compileLogical(name, v)
+		default:
+			if strings.HasPrefix(name, "$") {
+				return nil, fmt.Errorf("unknown condition operator %q", name)
+			}
+			node, err = compileField(name, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// compileLogical compiles $and, $or or $not, whose value is an array of conditions.
+func compileLogical(op string, v RawValue) (matchNode, error) {
+	if v.Type != BsonTypeArray {
+		return nil, fmt.Errorf("invalid condition %s: %v is not an array", op, v)
+	}
+
+	var nodes []matchNode
+	it := v.Array().Iterator()
+	for it.Next() {
+		c, ok := it.RawValue().DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("invalid condition %s: %v is not a document", op, it.RawValue())
+		}
+		node, err := compileCondition(c.raw)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("invalid condition %s: empty array", op)
+	}
+
+	switch op {
+	case "$and":
+		return andNode(nodes), nil
+	case "$or":
+		return orNode(nodes), nil
+	default:
+		return notNode(nodes), nil
+	}
+}
+
+func compileField(name string, v RawValue) (matchNode, error) {
+	path, err := splitPath(name)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := compileValue(name, v)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldNode{path: path, ops: ops}, nil
+}
+
+// isOperatorDocument reports whether v is a document of operators, e.g. {"$gt": 1}.
+func isOperatorDocument(v RawValue) bool {
+	if v.Type != BsonTypeBson {
+		return false
+	}
+	var it BsonIterator
+	it.init(v.Value)
+	return it.Next() && strings.HasPrefix(it.Name(), "$")
+}
+
+// compileValue compiles the condition of a field, which is a document of operators or a value.
+func compileValue(name string, v RawValue) ([]valueMatcher, error) {
+	if !isOperatorDocument(v) {
+		if v.Type == BsonTypeRegEx {
+			op, err := newRegexMatcher(v.RegEx())
+			if err != nil {
+				return nil, fmt.Errorf("invalid condition of %q: %v", name, err)
+			}
+			return []valueMatcher{op}, nil
+		}
+		return []valueMatcher{&compareMatcher{op: "$et", value: v}}, nil
+	}
+
+	var ops []valueMatcher
+	d := v.Document()
+	it := d.Iterator()
+	for it.Next() {
+		op, err := compileOperator(d, it.Name(), it.RawValue())
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition of %q: %v", name, err)
+		}
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// compileOperator compiles the operator op of the operator document d, nil is returned
+// for the operators which are the options of the others.
+func compileOperator(d *Bson, op string, v RawValue) (valueMatcher, error) {
+	switch op {
+	case "$et", "$gt", "$gte", "$lt", "$lte":
+		return &compareMatcher{op: op, value: v}, nil
+	case "$ne":
+		return notMatcher{&compareMatcher{op: "$et", value: v}}, nil
+	case "$in", "$nin", "$all":
+		values, ok := v.ArrayOK()
+		if !ok {
+			return nil, fmt.Errorf("%s: %v is not an array", op, v)
+		}
+		m := &inMatcher{}
+		it := values.Iterator()
+		for it.Next() {
+			m.values = append(m.values, it.RawValue())
+		}
+		switch op {
+		case "$nin":
+			return notMatcher{m}, nil
+		case "$all":
+			return allMatcher{m}, nil
+		}
+		return m, nil
+	case "$exists", "$isnull":
+		b, ok := conditionBool(v)
+		if !ok {
+			return nil, fmt.Errorf("%s: %v is not 0 or 1", op, v)
+		}
+		if op == "$exists" {
+			return existsMatcher(b), nil
+		}
+		return isNullMatcher(b), nil
+	case "$size":
+		n, ok := conditionInt(v)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("$size: %v is not a non-negative integer", v)
+		}
+		return sizeMatcher(n), nil
+	case "$type":
+		t, err := conditionType(v)
+		if err != nil {
+			return nil, err
+		}
+		return typeMatcher(t), nil
+	case "$regex":
+		var re RegEx
+		switch v.Type {
+		case BsonTypeRegEx:
+			re = v.RegEx()
+		case BsonTypeString:
+			re.Pattern = v.StringValue()
+			if o, err := d.LookupPath("$options"); err == nil {
+				options, ok := o.StringValueOK()
+				if !ok {
+					return nil, fmt.Errorf("$options: %v is not a string", o)
+				}
+				re.Options = options
+			}
+		default:
+			return nil, fmt.Errorf("$regex: %v is not a string or regex", v)
+		}
+		return newRegexMatcher(re)
+	case "$options":
+		if _, err := d.LookupPath("$regex"); err != nil {
+			return nil, fmt.Errorf("$options without $regex")
+		}
+		return nil, nil
+	case "$elemMatch":
+		return compileElemMatch(v)
+	case "$not":
+		if !isOperatorDocument(v) {
+			return nil, fmt.Errorf("$not: %v is not a document of operators", v)
+		}
+		ops, err := compileValue(op, v)
+		if err != nil {
+			return nil, err
+		}
+		return notMatcher{allOps(ops)}, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+}
+
+// compileElemMatch compiles the condition of $elemMatch, which is a document
+// condition or a document of operators of the elements.
+func compileElemMatch(v RawValue) (*elemMatcher, error) {
+	c, ok := v.DocumentOK()
+	if !ok {
+		return nil, fmt.Errorf("$elemMatch: %v is not a document", v)
+	}
+	if isOperatorDocument(v) {
+		ops, err := compileValue("$elemMatch", v)
+		if err != nil {
+			return nil, err
+		}
+		return &elemMatcher{ops: ops}, nil
+	}
+	node, err := compileCondition(c.raw)
+	if err != nil {
+		return nil, err
+	}
+	return &elemMatcher{cond: node}, nil
+}
+
+// conditionInt returns the integer of the number v.
+func conditionInt(v RawValue) (int64, bool) {
+	switch v.Type {
+	case BsonTypeInt32, BsonTypeInt64:
+		return integerValue(v), true
+	case BsonTypeFloat64:
+		f := v.Float64()
+		if f != float64(int64(f)) {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// conditionBool returns the bool of v, which is a bool, 0 or 1.
+func conditionBool(v RawValue) (bool, bool) {
+	if b, ok := v.BoolOK(); ok {
+		return b, true
+	}
+	n, ok := conditionInt(v)
+	if !ok || n != 0 && n != 1 {
+		return false, false
+	}
+	return n == 1, true
+}
+
+// conditionType returns the bson type of v, which is the number or name of the type.
+func conditionType(v RawValue) (BsonType, error) {
+	if n, ok := conditionInt(v); ok {
+		t := BsonType(n)
+		if _, valid := bsonTypeNames[t]; valid && int64(t) == n && t != BsonTypeEOD {
+			return t, nil
+		}
+	} else if s, ok := v.StringValueOK(); ok {
+		for t, name := range bsonTypeNames {
+			if name == s && t != BsonTypeEOD {
+				return t, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("$type: invalid bson type %v", v)
+}
+
+// valueMatcher matches the values of a field, which are empty if the field doesn't exist.
+type valueMatcher interface {
+	match(values []RawValue) bool
+}
+
+// elementMatcher matches a single value.
+type elementMatcher interface {
+	matchValue(v RawValue) bool
+}
+
+// matchAny reports whether any of values or the elements of the arrays in values matches m.
+func matchAny(m elementMatcher, values []RawValue) bool {
+	for _, v := range values {
+		if m.matchValue(v) {
+			return true
+		}
+		if v.Type != BsonTypeArray {
+			continue
+		}
+		var it BsonIterator
+		it.init(v.Value)
+		for it.Next() {
+			if m.matchValue(it.RawValue()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type compareMatcher struct {
+	op    string
+	value RawValue
+}
+
+func (m *compareMatcher) match(values []RawValue) bool {
+	return matchAny(m, values)
+}
+
+func (m *compareMatcher) matchValue(v RawValue) bool {
+	if m.op == "$et" {
+		return v.Compare(m.value) == 0
+	}
+	if typeOrder(v.Type) != typeOrder(m.value.Type) &&
+		m.value.Type != BsonTypeMinKey && m.value.Type != BsonTypeMaxKey {
+		return false
+	}
+
+	c := v.Compare(m.value)
+	switch m.op {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	default: // $lte
+		return c <= 0
+	}
+}
+
+// inMatcher matches the values equal to any of its values.
+type inMatcher struct {
+	values []RawValue
+}
+
+func (m *inMatcher) match(values []RawValue) bool {
+	return matchAny(m, values)
+}
+
+func (m *inMatcher) matchValue(v RawValue) bool {
+	for _, value := range m.values {
+		if v.Compare(value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// allMatcher matches the values containing all of its values.
+type allMatcher struct {
+	*inMatcher
+}
+
+func (m allMatcher) match(values []RawValue) bool {
+	if len(m.values) == 0 {
+		return false
+	}
+	for _, value := range m.values {
+		if !matchAny(&compareMatcher{op: "$et", value: value}, values) {
+			return false
+		}
+	}
+	return true
+}
+
+type notMatcher struct {
+	m valueMatcher
+}
+
+func (m notMatcher) match(values []RawValue) bool {
+	return !m.m.match(values)
+}
+
+type allOps []valueMatcher
+
+func (ops allOps) match(values []RawValue) bool {
+	return matchAll(ops, values)
+}
+
+type existsMatcher bool
+
+func (m existsMatcher) match(values []RawValue) bool {
+	return (len(values) > 0) == bool(m)
+}
+
+// isNullMatcher matches the values which are null or don't exist, or the others if it is false.
+type isNullMatcher bool
+
+func (m isNullMatcher) match(values []RawValue) bool {
+	null := len(values) == 0
+	for _, v := range values {
+		if v.IsNull() {
+			null = true
+			break
+		}
+	}
+	return null == bool(m)
+}
+
+// sizeMatcher matches the arrays of the number of elements.
+type sizeMatcher int64
+
+func (m sizeMatcher) match(values []RawValue) bool {
+	for _, v := range values {
+		if v.Type == BsonTypeArray && elementCount(v.Value) == int(m) {
+			return true
+		}
+	}
+	return false
+}
+
+type typeMatcher BsonType
+
+func (m typeMatcher) match(values []RawValue) bool {
+	return matchAny(m, values)
+}
+
+func (m typeMatcher) matchValue(v RawValue) bool {
+	return v.Type == BsonType(m)
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func newRegexMatcher(re RegEx) (*regexMatcher, error) {
+	flags := ""
+	for _, o := range re.Options {
+		switch o {
+		case 'i', 'm', 's':
+			if !strings.ContainsRune(flags, o) {
+				flags += string(o)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported regex option %q", o)
+		}
+	}
+	pattern := re.Pattern
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &regexMatcher{re: r}, nil
+}
+
+func (m *regexMatcher) match(values []RawValue) bool {
+	return matchAny(m, values)
+}
+
+func (m *regexMatcher) matchValue(v RawValue) bool {
+	if v.Type != BsonTypeString && v.Type != BsonTypeSymbol {
+		return false
+	}
+	return m.re.Match(stringBytes(v.Value))
+}
+
+// elemMatcher matches the arrays with any element matching the condition,
+// which is a document condition or operators of the elements.
+type elemMatcher struct {
+	cond matchNode
+	ops  []valueMatcher
+}
+
+func (m *elemMatcher) match(values []RawValue) bool {
+	for _, v := range values {
+		if v.Type != BsonTypeArray {
+			continue
+		}
+		var it BsonIterator
+		it.init(v.Value)
+		for it.Next() {
+			if m.matchElement(it.RawValue()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *elemMatcher) matchElement(e RawValue) bool {
+	if m.cond != nil {
+		return e.Type == BsonTypeBson && m.cond.match(e.Value)
+	}
+	return matchAll(m.ops, []RawValue{e})
+}
@@ -0,0 +1,194 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+var matchDoc = bson.Doc{
+	{"a", 1},
+	{"b", bson.Doc{{"c", "hello"}, {"d", 2.5}}},
+	{"e", []interface{}{1, 2, 3}},
+	{"f", []interface{}{bson.Doc{{"g", 1}, {"h", "x"}}, bson.Doc{{"g", 2}, {"h", "y"}}}},
+	{"n", nil},
+	{"s", "World"},
+}.Bson()
+
+func TestMatcher(t *testing.T) {
+	var tests = []struct {
+		cond     bson.Doc
+		expected bool
+	}{
+		{bson.Doc{}, true},
+		{bson.Doc{{"a", 1}}, true},
+		{bson.Doc{{"a", 1.0}}, true},
+		{bson.Doc{{"a", int64(2)}}, false},
+		{bson.Doc{{"a", 1}, {"s", "World"}}, true},
+		{bson.Doc{{"a", 1}, {"s", "world"}}, false},
+		{bson.Doc{{"b.c", "hello"}}, true},
+		{bson.Doc{{"b", bson.Doc{{"c", "hello"}, {"d", 2.5}}}}, true},
+		{bson.Doc{{"b", bson.Doc{{"c", "hello"}}}}, false},
+		{bson.Doc{{"x", 1}}, false},
+		{bson.Doc{{"a.x", 1}}, false},
+
+		{bson.Doc{{"a", bson.Doc{{"$et", 1}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$ne", 1}}}}, false},
+		{bson.Doc{{"x", bson.Doc{{"$ne", 1}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$gt", 0}, {"$lt", 2}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$gt", 1}}}}, false},
+		{bson.Doc{{"a", bson.Doc{{"$gte", 1}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$lte", 0.5}}}}, false},
+		{bson.Doc{{"a", bson.Doc{{"$lt", "x"}}}}, false},
+		{bson.Doc{{"a", bson.Doc{{"$gt", bson.MinKey}}}}, true},
+		{bson.Doc{{"b.d", bson.Doc{{"$gt", 2}}}}, true},
+		{bson.Doc{{"s", bson.Doc{{"$gt", "A"}}}}, true},
+
+		// arrays
+		{bson.Doc{{"e", 2}}, true},
+		{bson.Doc{{"e", 4}}, false},
+		{bson.Doc{{"e", []interface{}{1, 2, 3}}}, true},
+		{bson.Doc{{"e", bson.Doc{{"$gt", 2}}}}, true},
+		{bson.Doc{{"e", bson.Doc{{"$gt", 3}}}}, false},
+		{bson.Doc{{"e", bson.Doc{{"$ne", 2}}}}, false},
+		{bson.Doc{{"e.1", 2}}, true},
+		{bson.Doc{{"f.g", 2}}, true},
+		{bson.Doc{{"f.h", "z"}}, false},
+		{bson.Doc{{"f.1.h", "y"}}, true},
+		{bson.Doc{{"f.g", 1}, {"f.h", "y"}}, true},
+
+		{bson.Doc{{"a", bson.Doc{{"$in", []interface{}{3, 1}}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$in", []interface{}{}}}}}, false},
+		{bson.Doc{{"e", bson.Doc{{"$in", []interface{}{5, 3}}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$nin", []interface{}{3, 1}}}}}, false},
+		{bson.Doc{{"x", bson.Doc{{"$nin", []interface{}{3, 1}}}}}, true},
+		{bson.Doc{{"e", bson.Doc{{"$all", []interface{}{3, 1}}}}}, true},
+		{bson.Doc{{"e", bson.Doc{{"$all", []interface{}{3, 4}}}}}, false},
+		{bson.Doc{{"e", bson.Doc{{"$all", []interface{}{}}}}}, false},
+
+		{bson.Doc{{"a", bson.Doc{{"$exists", 1}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$exists", 0}}}}, false},
+		{bson.Doc{{"x", bson.Doc{{"$exists", false}}}}, true},
+		{bson.Doc{{"n", bson.Doc{{"$exists", 1}}}}, true},
+		{bson.Doc{{"n", bson.Doc{{"$isnull", 1}}}}, true},
+		{bson.Doc{{"x", bson.Doc{{"$isnull", 1}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$isnull", 1}}}}, false},
+		{bson.Doc{{"a", bson.Doc{{"$isnull", 0}}}}, true},
+		{bson.Doc{{"n", nil}}, true},
+
+		{bson.Doc{{"s", bson.Doc{{"$regex", "^wor"}, {"$options", "i"}}}}, true},
+		{bson.Doc{{"s", bson.Doc{{"$regex", "^wor"}}}}, false},
+		{bson.Doc{{"s", bson.Doc{{"$regex", bson.RegEx{Pattern: "ld$"}}}}}, true},
+		{bson.Doc{{"b.c", bson.RegEx{Pattern: "^h.*o$"}}}, true},
+		{bson.Doc{{"f.h", bson.RegEx{Pattern: "^[xz]$"}}}, true},
+		{bson.Doc{{"a", bson.RegEx{Pattern: "1"}}}, false},
+
+		{bson.Doc{{"e", bson.Doc{{"$size", 3}}}}, true},
+		{bson.Doc{{"e", bson.Doc{{"$size", 2}}}}, false},
+		{bson.Doc{{"a", bson.Doc{{"$size", 1}}}}, false},
+
+		{bson.Doc{{"f", bson.Doc{{"$elemMatch", bson.Doc{{"g", 1}, {"h", "x"}}}}}}, true},
+		{bson.Doc{{"f", bson.Doc{{"$elemMatch", bson.Doc{{"g", 1}, {"h", "y"}}}}}}, false},
+		{bson.Doc{{"e", bson.Doc{{"$elemMatch", bson.Doc{{"$gt", 1}, {"$lt", 3}}}}}}, true},
+		{bson.Doc{{"e", bson.Doc{{"$elemMatch", bson.Doc{{"$gt", 3}}}}}}, false},
+
+		{bson.Doc{{"a", bson.Doc{{"$type", 16}}}}, true},
+		{bson.Doc{{"a", bson.Doc{{"$type", "string"}}}}, false},
+		{bson.Doc{{"e", bson.Doc{{"$type", "array"}}}}, true},
+		{bson.Doc{{"b", bson.Doc{{"$type", 3}}}}, true},
+
+		{bson.Doc{{"$and", []interface{}{bson.Doc{{"a", 1}}, bson.Doc{{"s", "World"}}}}}, true},
+		{bson.Doc{{"$and", []interface{}{bson.Doc{{"a", 1}}, bson.Doc{{"s", "x"}}}}}, false},
+		{bson.Doc{{"$or", []interface{}{bson.Doc{{"a", 2}}, bson.Doc{{"s", "World"}}}}}, true},
+		{bson.Doc{{"$or", []interface{}{bson.Doc{{"a", 2}}, bson.Doc{{"s", "x"}}}}}, false},
+		{bson.Doc{{"$not", []interface{}{bson.Doc{{"a", 1}}, bson.Doc{{"s", "x"}}}}}, true},
+		{bson.Doc{{"$not", []interface{}{bson.Doc{{"a", 1}}}}}, false},
+		{bson.Doc{{"a", bson.Doc{{"$not", bson.Doc{{"$gt", 5}}}}}}, true},
+		{bson.Doc{{"$or", []interface{}{bson.Doc{{"$and", []interface{}{bson.Doc{{"a", 1}}, bson.Doc{{"x", 1}}}}}, bson.Doc{{"f.g", bson.Doc{{"$in", []interface{}{2}}}}}}}}, true},
+		{bson.Doc{{"a", 1}, {"$or", []interface{}{bson.Doc{{"a", 2}}}}}, false},
+	}
+
+	for _, test := range tests {
+		m, err := bson.CompileMatcher(test.cond)
+		if err != nil {
+			t.Errorf("compile %v: %v", test.cond, err)
+			continue
+		}
+		if m.Match(matchDoc) != test.expected {
+			t.Errorf("match %v: expected %v", test.cond, test.expected)
+		}
+	}
+}
+
+func TestMatcherMap(t *testing.T) {
+	m, err := bson.CompileMatcher(bson.Doc{{"a", bson.Map{"$gte": 1, "$lte": 1}}, {"b", bson.Map{"c": "hello", "d": 2.5}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Match(matchDoc) {
+		t.Errorf("match %v: expected true", m)
+	}
+}
+
+func TestMatcherError(t *testing.T) {
+	var tests = []bson.Doc{
+		{{"$xor", []interface{}{bson.Doc{{"a", 1}}}}},
+		{{"$and", bson.Doc{{"a", 1}}}},
+		{{"$or", []interface{}{}}},
+		{{"$or", []interface{}{1}}},
+		{{"$not", []interface{}{bson.Doc{{"$foo", 1}}}}},
+		{{"a..b", 1}},
+		{{"a", bson.Doc{{"$gt", 1}, {"$foo", 1}}}},
+		{{"a", bson.Doc{{"$in", 1}}}},
+		{{"a", bson.Doc{{"$exists", 2}}}},
+		{{"a", bson.Doc{{"$isnull", "yes"}}}},
+		{{"a", bson.Doc{{"$size", -1}}}},
+		{{"a", bson.Doc{{"$size", 1.5}}}},
+		{{"a", bson.Doc{{"$type", 99}}}},
+		{{"a", bson.Doc{{"$type", "int"}}}},
+		{{"a", bson.Doc{{"$regex", "("}}}},
+		{{"a", bson.Doc{{"$regex", "a"}, {"$options", "x"}}}},
+		{{"a", bson.Doc{{"$regex", 1}}}},
+		{{"a", bson.Doc{{"$options", "i"}}}},
+		{{"a", bson.Doc{{"$elemMatch", 1}}}},
+		{{"a", bson.Doc{{"$not", 1}}}},
+		{{"a", 1 + 2i}},
+	}
+
+	for _, cond := range tests {
+		if _, err := bson.CompileMatcher(cond); err == nil {
+			t.Errorf("compile %v: expected error", cond)
+		}
+	}
+}
+
+func BenchmarkSdbBsonMatch(t *testing.B) {
+	m, err := bson.CompileMatcher(bson.Doc{
+		{"a", bson.Doc{{"$gte", 1}}},
+		{"b.c", bson.Doc{{"$in", []interface{}{"hi", "hello"}}}},
+		{"f.h", "y"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.ResetTimer()
+	for i := 0; i < t.N; i++ {
+		if !m.Match(matchDoc) {
+			t.Fatal("expected match")
+		}
+	}
+}
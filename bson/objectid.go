@@ -19,10 +19,11 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"sync/atomic"
 	"time"
 )
 
@@ -31,10 +32,6 @@ import (
 // SequoiaDB objects by default have such a property set in their "_id" property.
 type ObjectId string
 
-var machineId = getMachineId()
-
-var objectIdCounter uint32 = getRandomUint32()
-
 func getMachineId() []byte {
 	var b [3]byte
 	id := b[:]
@@ -61,26 +58,37 @@ func getRandomUint32() uint32 {
 	return uint32((uint32(b[0]) << 0) | (uint32(b[1]) << 8) | (uint32(b[2]) << 16) | (uint32(b[3]) << 24))
 }
 
+// NewObjectId returns a new ObjectId by the global ObjectIdGenerator.
 func NewObjectId() ObjectId {
+	return GetObjectIdGenerator().NewObjectId()
+}
+
+// NewObjectIdWithTime returns an ObjectId with the time part of t and the other parts zero.
+// It is only useful for range queries on ObjectIds, e.g. {"_id": {"$gte": NewObjectIdWithTime(t)}}.
+func NewObjectIdWithTime(t time.Time) ObjectId {
 	var b [12]byte
-	// timestamp, 4 bytes, big endian
-	binary.BigEndian.PutUint32(b[:], uint32(time.Now().Unix()))
-	// machineId, 3 bytes
-	b[4] = machineId[0]
-	b[5] = machineId[1]
-	b[6] = machineId[2]
-	// pid, 2 bytes, big endian
-	pid := os.Getpid()
-	b[7] = byte(pid >> 8)
-	b[8] = byte(pid)
-	// counter, 3 bytes, big endian
-	i := atomic.AddUint32(&objectIdCounter, 1)
-	b[9] = byte(i >> 16)
-	b[10] = byte(i >> 8)
-	b[11] = byte(i)
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
 	return ObjectId(b[:])
 }
 
+// ObjectIdFromHex returns the ObjectId of the 24 hex characters s.
+func ObjectIdFromHex(s string) (ObjectId, error) {
+	if len(s) != 24 {
+		return "", fmt.Errorf("invalid ObjectId hex %q: expected 24 hex characters", s)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid ObjectId hex %q", s)
+	}
+	return ObjectId(b), nil
+}
+
+// IsObjectIdHex reports whether s is a valid hex representation of an ObjectId.
+func IsObjectIdHex(s string) bool {
+	_, err := ObjectIdFromHex(s)
+	return err == nil
+}
+
 func (id ObjectId) IsValid() bool {
 	return len(id) == 12
 }
@@ -92,3 +100,94 @@ func (id ObjectId) String() string {
 func (id ObjectId) Hex() string {
 	return hex.EncodeToString([]byte(id))
 }
+
+func (id ObjectId) part(start, end int) []byte {
+	if !id.IsValid() {
+		panic(fmt.Sprintf("invalid ObjectId: %q", string(id)))
+	}
+	return []byte(id[start:end])
+}
+
+// Time returns the creation time of id as a UTC time.Time, in seconds precision.
+func (id ObjectId) Time() time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint32(id.part(0, 4))), 0).UTC()
+}
+
+// Machine returns the 3 bytes machine id part of id.
+func (id ObjectId) Machine() []byte {
+	return id.part(4, 7)
+}
+
+// Pid returns the process id part of id.
+func (id ObjectId) Pid() uint16 {
+	return binary.BigEndian.Uint16(id.part(7, 9))
+}
+
+// Counter returns the incrementing counter part of id.
+func (id ObjectId) Counter() int32 {
+	b := id.part(9, 12)
+	return int32(uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]))
+}
+
+// MarshalText returns the hex representation of id, or empty text if id is empty.
+func (id ObjectId) MarshalText() ([]byte, error) {
+	if id == "" {
+		return []byte{}, nil
+	}
+	if !id.IsValid() {
+		return nil, fmt.Errorf("invalid ObjectId: %q", string(id))
+	}
+	return []byte(id.Hex()), nil
+}
+
+// UnmarshalText sets id from its hex representation, empty text means an empty id.
+func (id *ObjectId) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = ""
+		return nil
+	}
+	oid, err := ObjectIdFromHex(string(text))
+	if err != nil {
+		return err
+	}
+	*id = oid
+	return nil
+}
+
+// MarshalJSON returns id as a JSON string of hex, or null if id is empty.
+func (id ObjectId) MarshalJSON() ([]byte, error) {
+	if id == "" {
+		return []byte("null"), nil
+	}
+	text, err := id.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(`"` + string(text) + `"`), nil
+}
+
+// UnmarshalJSON accepts a JSON string of hex, null, or the extended JSON {"$oid":"hex"}.
+func (id *ObjectId) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*id = ""
+		return nil
+	}
+	if len(s) > 0 && s[0] == '{' {
+		var v struct {
+			Oid *string `json:"$oid"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if v.Oid == nil {
+			return errors.New("invalid ObjectId json: missing $oid")
+		}
+		return id.UnmarshalText([]byte(*v.Oid))
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid ObjectId json: %s", s)
+	}
+	return id.UnmarshalText([]byte(text))
+}
@@ -15,7 +15,9 @@
 package bson_test
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/davidli2010/gobson_exp/bson"
 )
@@ -26,3 +28,149 @@ func TestObjectId(t *testing.T) {
 		t.Errorf("invalid ObjectId: %s", oid)
 	}
 }
+
+func TestObjectIdParts(t *testing.T) {
+	oid, err := bson.ObjectIdFromHex("567a3b2c1d0e9f8a7b6c5d4e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !oid.Time().Equal(time.Unix(0x567a3b2c, 0)) || oid.Time().Location() != time.UTC {
+		t.Errorf("invalid time: %v", oid.Time())
+	}
+	if string(oid.Machine()) != "\x1d\x0e\x9f" {
+		t.Errorf("invalid machine: %x", oid.Machine())
+	}
+	if oid.Pid() != 0x8a7b {
+		t.Errorf("invalid pid: %x", oid.Pid())
+	}
+	if oid.Counter() != 0x6c5d4e {
+		t.Errorf("invalid counter: %x", oid.Counter())
+	}
+	if oid.Hex() != "567a3b2c1d0e9f8a7b6c5d4e" {
+		t.Errorf("invalid hex: %s", oid.Hex())
+	}
+
+	now := time.Now()
+	oid = bson.NewObjectId()
+	if d := oid.Time().Sub(now); d < -time.Second || d > time.Second {
+		t.Errorf("invalid time of new ObjectId: %v", oid.Time())
+	}
+
+	oid = bson.NewObjectIdWithTime(time.Unix(1451606400, 999))
+	if oid.Hex() != "5685c1800000000000000000" {
+		t.Errorf("invalid ObjectId with time: %s", oid.Hex())
+	}
+
+	// the time round-trips in UTC whatever the time zone of t is
+	zone := time.FixedZone("UTC+8", 8*3600)
+	tm := time.Date(2016, 1, 1, 8, 0, 0, 0, zone)
+	if rt := bson.NewObjectIdWithTime(tm).Time(); rt != tm.UTC() || rt.Location() != time.UTC {
+		t.Errorf("invalid time of ObjectId with time %v: %v", tm, rt)
+	}
+}
+
+func TestObjectIdHex(t *testing.T) {
+	var tests = []struct {
+		hex   string
+		valid bool
+	}{
+		{"567a3b2c1d0e9f8a7b6c5d4e", true},
+		{"567A3B2C1D0E9F8A7B6C5D4E", true},
+		{"567a3b2c1d0e9f8a7b6c5d4", false},
+		{"567a3b2c1d0e9f8a7b6c5d4e0", false},
+		{"567a3b2c1d0e9f8a7b6c5d4g", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if bson.IsObjectIdHex(test.hex) != test.valid {
+			t.Errorf("%q: expected valid %v", test.hex, test.valid)
+		}
+		_, err := bson.ObjectIdFromHex(test.hex)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: unexpected error %v", test.hex, err)
+		}
+	}
+}
+
+func TestObjectIdJSON(t *testing.T) {
+	type st struct {
+		Id    bson.ObjectId
+		Empty bson.ObjectId
+		Ptr   *bson.ObjectId
+	}
+
+	oid, _ := bson.ObjectIdFromHex("567a3b2c1d0e9f8a7b6c5d4e")
+	data, err := json.Marshal(st{Id: oid, Ptr: &oid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Id":"567a3b2c1d0e9f8a7b6c5d4e","Empty":null,"Ptr":"567a3b2c1d0e9f8a7b6c5d4e"}`
+	if string(data) != want {
+		t.Errorf("expected %s, actual %s", want, data)
+	}
+
+	var s st
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Id != oid || s.Empty != "" || s.Ptr == nil || *s.Ptr != oid {
+		t.Errorf("invalid json unmarshal: %+v", s)
+	}
+
+	var s2 st
+	if err := json.Unmarshal([]byte(`{"Id":{"$oid":"567a3b2c1d0e9f8a7b6c5d4e"}}`), &s2); err != nil || s2.Id != oid {
+		t.Errorf("invalid extended json unmarshal: %v, %v", s2.Id, err)
+	}
+
+	for _, data := range []string{`{"Id":"xyz"}`, `{"Id":1}`, `{"Id":{"oid":"567a3b2c1d0e9f8a7b6c5d4e"}}`} {
+		if err := json.Unmarshal([]byte(data), &s2); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+}
+
+func TestObjectIdText(t *testing.T) {
+	type st struct {
+		Id  bson.ObjectId
+		Ids map[string]bson.ObjectId
+	}
+
+	oid := bson.NewObjectId()
+	text, err := oid.MarshalText()
+	if err != nil || string(text) != oid.Hex() {
+		t.Errorf("invalid text: %s, %v", text, err)
+	}
+
+	var oid2 bson.ObjectId
+	if err := oid2.UnmarshalText(text); err != nil || oid2 != oid {
+		t.Errorf("invalid unmarshal text: %v, %v", oid2, err)
+	}
+
+	// still ObjectId in bson, and hex strings can be decoded
+	b := bson.StructToBson(st{Id: oid})
+	if it := b.Iterator(); !it.Next() || it.BsonType() != bson.BsonTypeObjectId {
+		t.Errorf("invalid bson of ObjectId: %s", b)
+	}
+
+	var s st
+	bson.Doc{{"Id", oid.Hex()}, {"Ids", bson.Doc{{"a", oid}}}}.Bson().Struct(&s)
+	if s.Id != oid || s.Ids["a"] != oid {
+		t.Errorf("invalid ObjectId field mapping: %+v", s)
+	}
+
+	// other strings are skipped, or errors of StructE
+	type idStruct struct {
+		Id   bson.ObjectId `bson:"_id"`
+		Name string
+	}
+	var s2 idStruct
+	d := bson.Doc{{"_id", "abc"}, {"Name", "a"}}
+	d.Bson().Struct(&s2)
+	if s2.Id != "" || s2.Name != "a" {
+		t.Errorf("invalid struct of string _id: %+v", s2)
+	}
+	if err := d.Bson().StructE(&s2, nil); err == nil {
+		t.Errorf("expected error of string _id")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ObjectIdGenerator generates ObjectIds, it must be safe for concurrent use.
+type ObjectIdGenerator interface {
+	NewObjectId() ObjectId
+}
+
+// objectIdGenerator generates ObjectIds of
+// 4 bytes seconds, 5 bytes process unique value and 3 bytes counter.
+type objectIdGenerator struct {
+	now     func() time.Time
+	process [5]byte
+	counter uint32
+}
+
+func (g *objectIdGenerator) NewObjectId() ObjectId {
+	var b [12]byte
+	// timestamp, 4 bytes, big endian
+	binary.BigEndian.PutUint32(b[:], uint32(g.now().Unix()))
+	// process unique value, 5 bytes
+	copy(b[4:9], g.process[:])
+	// counter, 3 bytes, big endian
+	i := atomic.AddUint32(&g.counter, 1) - 1
+	b[9] = byte(i >> 16)
+	b[10] = byte(i >> 8)
+	b[11] = byte(i)
+	return ObjectId(b[:])
+}
+
+// NewDefaultObjectIdGenerator returns the generator of the classic layout:
+// 3 bytes MD5 of the hostname and 2 bytes pid. The pid is folded into 16 bits
+// so that the high bits are not simply dropped.
+func NewDefaultObjectIdGenerator() ObjectIdGenerator {
+	g := &objectIdGenerator{now: time.Now, counter: getRandomUint32()}
+	copy(g.process[:3], getMachineId())
+	pid := uint32(os.Getpid())
+	binary.BigEndian.PutUint16(g.process[3:], uint16(pid^pid>>16))
+	return g
+}
+
+// NewRandomObjectIdGenerator returns the generator of the modern layout
+// whose 5 bytes process unique value is random, instead of the hostname and pid.
+func NewRandomObjectIdGenerator() ObjectIdGenerator {
+	g := &objectIdGenerator{now: time.Now, counter: getRandomUint32()}
+	if _, err := io.ReadFull(rand.Reader, g.process[:]); err != nil {
+		panic(fmt.Errorf("can't get random bytes: %v", err))
+	}
+	return g
+}
+
+// NewSeededObjectIdGenerator returns a deterministic generator for tests.
+// All ObjectIds have the time t and the 5 bytes value derived from seed,
+// and the counter starts from 0.
+func NewSeededObjectIdGenerator(seed int64, t time.Time) ObjectIdGenerator {
+	g := &objectIdGenerator{now: func() time.Time { return t }}
+	mrand.New(mrand.NewSource(seed)).Read(g.process[:])
+	return g
+}
+
+type objectIdGeneratorHolder struct {
+	g ObjectIdGenerator
+}
+
+var globalObjectIdGenerator atomic.Value
+
+func init() {
+	globalObjectIdGenerator.Store(objectIdGeneratorHolder{NewDefaultObjectIdGenerator()})
+}
+
+// SetObjectIdGenerator sets the global generator used by NewObjectId,
+// nil restores the default generator.
+func SetObjectIdGenerator(g ObjectIdGenerator) {
+	if g == nil {
+		g = NewDefaultObjectIdGenerator()
+	}
+	globalObjectIdGenerator.Store(objectIdGeneratorHolder{g})
+}
+
+// GetObjectIdGenerator returns the global generator used by NewObjectId.
+func GetObjectIdGenerator() ObjectIdGenerator {
+	return globalObjectIdGenerator.Load().(objectIdGeneratorHolder).g
+}
@@ -0,0 +1,101 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestSeededObjectIdGenerator(t *testing.T) {
+	tm := time.Unix(1451606400, 0)
+	g1 := bson.NewSeededObjectIdGenerator(42, tm)
+	g2 := bson.NewSeededObjectIdGenerator(42, tm)
+	g3 := bson.NewSeededObjectIdGenerator(43, tm)
+
+	for i := 0; i < 3; i++ {
+		id1, id2, id3 := g1.NewObjectId(), g2.NewObjectId(), g3.NewObjectId()
+		if id1 != id2 {
+			t.Errorf("expected the same ObjectId: %s, %s", id1.Hex(), id2.Hex())
+		}
+		if id1 == id3 {
+			t.Errorf("expected different ObjectIds: %s, %s", id1.Hex(), id3.Hex())
+		}
+		if !id1.Time().Equal(tm) || id1.Counter() != int32(i) {
+			t.Errorf("invalid ObjectId: %s", id1.Hex())
+		}
+	}
+}
+
+func TestObjectIdGenerators(t *testing.T) {
+	var tests = []bson.ObjectIdGenerator{
+		bson.NewDefaultObjectIdGenerator(),
+		bson.NewRandomObjectIdGenerator(),
+	}
+
+	for _, g := range tests {
+		id1, id2 := g.NewObjectId(), g.NewObjectId()
+		if !id1.IsValid() || !id2.IsValid() || id1 == id2 {
+			t.Errorf("invalid ObjectIds: %s, %s", id1.Hex(), id2.Hex())
+		}
+		if string(id1[4:9]) != string(id2[4:9]) || (id2.Counter()-id1.Counter())&0xFFFFFF != 1 {
+			t.Errorf("invalid ObjectIds: %s, %s", id1.Hex(), id2.Hex())
+		}
+	}
+
+	if r1, r2 := bson.NewRandomObjectIdGenerator().NewObjectId(), bson.NewRandomObjectIdGenerator().NewObjectId(); r1[4:9] == r2[4:9] {
+		t.Errorf("expected different random values: %s, %s", r1.Hex(), r2.Hex())
+	}
+}
+
+func TestSetObjectIdGenerator(t *testing.T) {
+	tm := time.Unix(1451606400, 0)
+	bson.SetObjectIdGenerator(bson.NewSeededObjectIdGenerator(1, tm))
+	defer bson.SetObjectIdGenerator(nil)
+
+	want := bson.NewSeededObjectIdGenerator(1, tm).NewObjectId()
+	if id := bson.NewObjectId(); id != want {
+		t.Errorf("expected %s, actual %s", want.Hex(), id.Hex())
+	}
+}
+
+func TestBuilderObjectIdGenerator(t *testing.T) {
+	tm := time.Unix(1451606400, 0)
+	g := bson.NewSeededObjectIdGenerator(1, tm)
+	b := bson.NewBsonBuilder().
+		SetObjectIdGenerator(bson.NewSeededObjectIdGenerator(1, tm)).
+		AppendNewObjectId("_id").
+		AppendBsonStart("obj").
+		AppendNewObjectId("id").
+		Finish().
+		AppendBsonEnd().
+		AppendArrayStart("ids").
+		AppendNewObjectId().
+		Finish().
+		AppendArrayEnd().
+		Finish().
+		Bson()
+
+	want := bson.Doc{
+		{"_id", g.NewObjectId()},
+		{"obj", bson.Doc{{"id", g.NewObjectId()}}},
+		{"ids", []interface{}{g.NewObjectId()}},
+	}.Bson()
+	if b.String() != want.String() {
+		t.Errorf("expected: %s\n  actual: %s", want, b)
+	}
+}
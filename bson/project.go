@@ -0,0 +1,295 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"fmt"
+)
+
+// Project returns the fields of doc selected by the SequoiaDB selector, doc is not changed.
+// The fields of the selector are dotted paths, which are looked up in the documents of arrays too:
+//
+//	{"a": ""}                          includes a, the value is ignored
+//	{"a": {"$include": 1}}             includes a, or excludes it if 0
+//	{"a": {"$default": 1}}             includes a, which is 1 if it doesn't exist
+//	{"a": {"$slice": 2}}               the first 2 elements of the array a, or the last ones if negative
+//	{"a": {"$slice": [1, 2]}}          2 elements of the array a after skipping 1, all the rest if -1
+//	{"a": {"$elemMatch": {"b": 1}}}    the elements of the array a matching the condition
+//
+// Only the selected fields are returned if any field is included, otherwise the fields except the
+// excluded ones are returned, the fields are in the order of doc. $slice doesn't decide either way,
+// the sliced field is returned in both cases.
+// It panics if the selector is invalid, or includes and excludes fields at the same time,
+// except excluding _id.
+func Project(doc *Bson, selector Doc) *Bson {
+	root, inclusion := compileSelector(selector)
+	return &Bson{raw: projectDocument(doc.raw, root, inclusion)}
+}
+
+// projectNode is a field of the selector, whose children are the fields of its dotted paths.
+type projectNode struct {
+	names    []string
+	children map[string]*projectNode
+
+	include    bool
+	exclude    bool
+	hasDefault bool
+	def        RawValue
+	slice      bool
+	skip       int64
+	limit      int64
+	elemMatch  *elemMatcher
+}
+
+func (n *projectNode) leaf() bool {
+	return n.children == nil
+}
+
+// defaults reports whether n or its children have default values.
+func (n *projectNode) defaults() bool {
+	if n.hasDefault {
+		return true
+	}
+	for _, c := range n.children {
+		if c.defaults() {
+			return true
+		}
+	}
+	return false
+}
+
+func compileSelector(selector Doc) (root *projectNode, inclusion bool) {
+	s, err := selector.BsonE()
+	if err != nil {
+		panic(err)
+	}
+
+	root = &projectNode{children: map[string]*projectNode{}}
+	exclusion := false
+	it := s.Iterator()
+	for it.Next() {
+		name := it.Name()
+		keys, err := splitPath(name)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		n := root
+		for i, key := range keys {
+			c, exist := n.children[key]
+			last := i == len(keys)-1
+			if exist && (last || c.leaf()) {
+				panic(fmt.Sprintf("conflicting selector of %q", name))
+			}
+			if !exist {
+				c = &projectNode{}
+				if !last {
+					c.children = map[string]*projectNode{}
+				}
+				n.children[key] = c
+				n.names = append(n.names, key)
+			}
+			n = c
+		}
+
+		compileSelectorField(name, n, it.RawValue())
+		if n.include {
+			inclusion = true
+		} else if n.exclude && name != "_id" {
+			exclusion = true
+		}
+	}
+
+	if inclusion && exclusion {
+		panic("can't include and exclude fields in the same selector")
+	}
+	return root, inclusion
+}
+
+func compileSelectorField(name string, n *projectNode, v RawValue) {
+	if !isOperatorDocument(v) {
+		n.include = true
+		return
+	}
+
+	it := v.Document().Iterator()
+	for it.Next() {
+		arg := it.RawValue()
+		switch it.Name() {
+		case "$include":
+			include, ok := conditionBool(arg)
+			if !ok {
+				panic(fmt.Sprintf("invalid selector of %q: $include %v is not 0 or 1", name, arg))
+			}
+			n.include, n.exclude = include, !include
+		case "$default":
+			n.include = true
+			n.hasDefault = true
+			n.def = arg
+		case "$slice":
+			n.slice = true
+			if count, ok := conditionInt(arg); ok {
+				if count >= 0 {
+					n.skip, n.limit = 0, count
+				} else {
+					n.skip, n.limit = count, -1
+				}
+				continue
+			}
+			a, ok := arg.ArrayOK()
+			var skip, limit RawValue
+			if ok && elementCount(a.bson.raw) == 2 {
+				skip, _ = lookupKey(a.bson.raw, "0")
+				limit, _ = lookupKey(a.bson.raw, "1")
+			}
+			var okSkip, okLimit bool
+			n.skip, okSkip = conditionInt(skip)
+			n.limit, okLimit = conditionInt(limit)
+			if !okSkip || !okLimit || n.limit < -1 {
+				panic(fmt.Sprintf("invalid selector of %q: $slice %v is not a number or [skip, limit]", name, arg))
+			}
+		case "$elemMatch":
+			m, err := compileElemMatch(arg)
+			if err != nil {
+				panic(fmt.Sprintf("invalid selector of %q: %v", name, err))
+			}
+			n.include = true
+			n.elemMatch = m
+		default:
+			panic(fmt.Sprintf("invalid selector of %q: unknown operator %q", name, it.Name()))
+		}
+	}
+	if n.include && n.exclude {
+		panic(fmt.Sprintf("invalid selector of %q: excluded with $default or $elemMatch", name))
+	}
+}
+
+// projectDocument returns the raw document of the fields of raw selected by node.
+func projectDocument(raw []byte, node *projectNode, inclusion bool) []byte {
+	b := NewBsonBuilderSize(len(raw))
+	seen := 0
+	var it BsonIterator
+	it.init(raw)
+	for it.Next() {
+		name := it.Name()
+		v := it.RawValue()
+		n, selected := node.children[name]
+		if !selected {
+			if !inclusion {
+				b.AppendRaw(name, v)
+			}
+			continue
+		}
+
+		seen++
+		if v, ok := projectValue(v, n, inclusion); ok {
+			b.AppendRaw(name, v)
+		}
+	}
+
+	if seen < len(node.names) {
+		for _, name := range node.names {
+			n := node.children[name]
+			if !n.defaults() || lookupExist(raw, name) {
+				continue
+			}
+			if n.leaf() {
+				b.AppendRaw(name, n.def)
+			} else {
+				b.AppendRaw(name, RawValue{Type: BsonTypeBson, Value: projectDocument(emptyDocument, n, inclusion)})
+			}
+		}
+	}
+
+	b.Finish()
+	return b.Raw()
+}
+
+func lookupExist(raw []byte, key string) bool {
+	_, found := lookupKey(raw, key)
+	return found
+}
+
+// projectValue returns the value v selected by n, false if it is not selected.
+func projectValue(v RawValue, n *projectNode, inclusion bool) (RawValue, bool) {
+	if !n.leaf() {
+		switch v.Type {
+		case BsonTypeBson:
+			return RawValue{Type: BsonTypeBson, Value: projectDocument(v.Value, n, inclusion)}, true
+		case BsonTypeArray:
+			// the documents of the array are projected, and the others are kept if excluding
+			a := NewBsonArrayBuilder()
+			it := v.Array().Iterator()
+			for it.Next() {
+				e := it.RawValue()
+				if e.Type == BsonTypeBson {
+					a.AppendRaw(RawValue{Type: BsonTypeBson, Value: projectDocument(e.Value, n, inclusion)})
+				} else if !inclusion {
+					a.AppendRaw(e)
+				}
+			}
+			a.Finish()
+			return RawValue{Type: BsonTypeArray, Value: a.Raw()}, true
+		default:
+			return v, !inclusion
+		}
+	}
+
+	if n.exclude || inclusion && !n.include && !n.slice {
+		return v, false
+	}
+	if v.Type != BsonTypeArray || !n.slice && n.elemMatch == nil {
+		return v, n.elemMatch == nil
+	}
+
+	var elems []RawValue
+	it := v.Array().Iterator()
+	for it.Next() {
+		e := it.RawValue()
+		if n.elemMatch == nil || n.elemMatch.matchElement(e) {
+			elems = append(elems, e)
+		}
+	}
+	if n.slice {
+		elems = sliceValues(elems, n.skip, n.limit)
+	}
+
+	a := NewBsonArrayBuilder()
+	for _, e := range elems {
+		a.AppendRaw(e)
+	}
+	a.Finish()
+	return RawValue{Type: BsonTypeArray, Value: a.Raw()}, true
+}
+
+// sliceValues returns limit values of values after skipping skip ones, a negative skip is
+// counted from the end, and a negative limit means all the rest.
+func sliceValues(values []RawValue, skip, limit int64) []RawValue {
+	size := int64(len(values))
+	if skip < 0 {
+		skip += size
+		if skip < 0 {
+			skip = 0
+		}
+	}
+	if skip > size {
+		skip = size
+	}
+	end := size
+	if limit >= 0 && skip+limit < size {
+		end = skip + limit
+	}
+	return values[skip:end]
+}
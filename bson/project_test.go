@@ -0,0 +1,104 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestProject(t *testing.T) {
+	doc := bson.Doc{
+		{"_id", 7},
+		{"a", 1},
+		{"b", bson.Doc{{"c", "x"}, {"d", 2}}},
+		{"e", []interface{}{1, 2, 3, 4, 5}},
+		{"f", []interface{}{bson.Doc{{"g", 1}, {"h", "x"}}, 9, bson.Doc{{"g", 2}}}},
+	}.Bson()
+	orig := doc.String()
+
+	var tests = []struct {
+		selector bson.Doc
+		expected string
+	}{
+		{bson.Doc{}, orig},
+		{bson.Doc{{"a", ""}}, `{"a":1}`},
+		{bson.Doc{{"b", ""}, {"a", 1}}, `{"a":1, "b":{"c":"x", "d":2}}`},
+		{bson.Doc{{"b.c", ""}}, `{"b":{"c":"x"}}`},
+		{bson.Doc{{"f.g", ""}}, `{"f":[{"g":1}, {"g":2}]}`},
+		{bson.Doc{{"a.x", ""}}, `{}`},
+		{bson.Doc{{"x", ""}}, `{}`},
+		{bson.Doc{{"a", bson.Doc{{"$include", 1}}}}, `{"a":1}`},
+		{bson.Doc{{"a", bson.Doc{{"$include", 0}}}, {"b.c", bson.Doc{{"$include", 0}}}},
+			`{"_id":7, "b":{"d":2}, "e":[1, 2, 3, 4, 5], "f":[{"g":1, "h":"x"}, 9, {"g":2}]}`},
+		{bson.Doc{{"f.h", bson.Doc{{"$include", false}}}}, `{"_id":7, "a":1, "b":{"c":"x", "d":2}, "e":[1, 2, 3, 4, 5], "f":[{"g":1}, 9, {"g":2}]}`},
+		{bson.Doc{{"_id", bson.Doc{{"$include", 0}}}, {"a", ""}}, `{"a":1}`},
+		{bson.Doc{{"a", bson.Doc{{"$default", 5}}}, {"x", bson.Doc{{"$default", "d"}}}}, `{"a":1, "x":"d"}`},
+		{bson.Doc{{"b.x", bson.Doc{{"$default", 0}}}, {"y.z", bson.Doc{{"$default", 1}}}}, `{"b":{"x":0}, "y":{"z":1}}`},
+		{bson.Doc{{"f.h", bson.Doc{{"$default", ""}}}}, `{"f":[{"h":"x"}, {"h":""}]}`},
+		{bson.Doc{{"e", bson.Doc{{"$slice", 2}}}}, `{"_id":7, "a":1, "b":{"c":"x", "d":2}, "e":[1, 2], "f":[{"g":1, "h":"x"}, 9, {"g":2}]}`},
+		{bson.Doc{{"a", ""}, {"e", bson.Doc{{"$slice", -2}}}}, `{"a":1, "e":[4, 5]}`},
+		{bson.Doc{{"a", ""}, {"e", bson.Doc{{"$slice", []interface{}{1, 2}}}}}, `{"a":1, "e":[2, 3]}`},
+		{bson.Doc{{"a", ""}, {"e", bson.Doc{{"$slice", []interface{}{-2, -1}}}}}, `{"a":1, "e":[4, 5]}`},
+		{bson.Doc{{"a", ""}, {"e", bson.Doc{{"$slice", []interface{}{9, 1}}}}}, `{"a":1, "e":[]}`},
+		{bson.Doc{{"a", bson.Doc{{"$slice", 1}}}, {"b", ""}}, `{"a":1, "b":{"c":"x", "d":2}}`},
+		{bson.Doc{{"f", bson.Doc{{"$elemMatch", bson.Doc{{"g", 2}}}}}}, `{"f":[{"g":2}]}`},
+		{bson.Doc{{"e", bson.Doc{{"$elemMatch", bson.Doc{{"$gt", 2}, {"$lt", 5}}}}}}, `{"e":[3, 4]}`},
+		{bson.Doc{{"e", bson.Doc{{"$elemMatch", bson.Doc{{"$gt", 1}}}, {"$slice", 2}}}}, `{"e":[2, 3]}`},
+		{bson.Doc{{"a", bson.Doc{{"$elemMatch", bson.Doc{{"g", 2}}}}}}, `{}`},
+	}
+
+	for _, test := range tests {
+		projected := bson.Project(doc, test.selector)
+		if err := projected.Validate(); err != nil {
+			t.Errorf("project %v: %v", test.selector, err)
+		}
+		if projected.String() != test.expected {
+			t.Errorf("project %v:\nexpected: %s\n  actual: %s", test.selector, test.expected, projected)
+		}
+		if doc.String() != orig {
+			t.Fatalf("project %v: the document is changed: %s", test.selector, doc)
+		}
+	}
+}
+
+func TestProjectPanic(t *testing.T) {
+	var tests = []bson.Doc{
+		{{"a", ""}, {"b", bson.Doc{{"$include", 0}}}},
+		{{"a", ""}, {"a.b", ""}},
+		{{"a.b", ""}, {"a", ""}},
+		{{"a..b", ""}},
+		{{"a", bson.Doc{{"$foo", 1}}}},
+		{{"a", bson.Doc{{"$include", 2}}}},
+		{{"a", bson.Doc{{"$include", 0}, {"$default", 1}}}},
+		{{"a", bson.Doc{{"$slice", "1"}}}},
+		{{"a", bson.Doc{{"$slice", []interface{}{1}}}}},
+		{{"a", bson.Doc{{"$slice", []interface{}{1, -2}}}}},
+		{{"a", bson.Doc{{"$elemMatch", 1}}}},
+	}
+
+	doc := bson.Doc{{"a", 1}}.Bson()
+	for _, selector := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("project %v: expected panic", selector)
+				}
+			}()
+			bson.Project(doc, selector)
+		}()
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RawValue is a bson value which references the bytes of its document.
+// Value is the value part of the element, e.g. the int32 length, the characters
+// and the trailing 0x00 of a string.
+type RawValue struct {
+	Type  BsonType
+	Value []byte
+}
+
+func (v RawValue) checkType(t BsonType) {
+	if v.Type != t {
+		panic(fmt.Sprintf("bson value of type %v is not of type %v", v.Type, t))
+	}
+}
+
+func (v RawValue) Float64() float64 {
+	v.checkType(BsonTypeFloat64)
+	return bytesToFloat64(v.Value)
+}
+
+func (v RawValue) Float64OK() (float64, bool) {
+	if v.Type != BsonTypeFloat64 {
+		return 0, false
+	}
+	return bytesToFloat64(v.Value), true
+}
+
+// StringValue returns the value of a string, use String to format any value.
+func (v RawValue) StringValue() string {
+	v.checkType(BsonTypeString)
+	len := bytesToInt32(v.Value)
+	return string(v.Value[4 : len+3])
+}
+
+func (v RawValue) StringValueOK() (string, bool) {
+	if v.Type != BsonTypeString {
+		return "", false
+	}
+	return v.StringValue(), true
+}
+
+func (v RawValue) Document() *Bson {
+	v.checkType(BsonTypeBson)
+	return &Bson{raw: v.Value}
+}
+
+func (v RawValue) DocumentOK() (*Bson, bool) {
+	if v.Type != BsonTypeBson {
+		return nil, false
+	}
+	return &Bson{raw: v.Value}, true
+}
+
+func (v RawValue) Array() *BsonArray {
+	v.checkType(BsonTypeArray)
+	return &BsonArray{bson: Bson{raw: v.Value}}
+}
+
+func (v RawValue) ArrayOK() (*BsonArray, bool) {
+	if v.Type != BsonTypeArray {
+		return nil, false
+	}
+	return &BsonArray{bson: Bson{raw: v.Value}}, true
+}
+
+func (v RawValue) Binary() Binary {
+	v.checkType(BsonTypeBinary)
+	len := bytesToInt32(v.Value)
+	return Binary{Subtype: BinaryType(v.Value[4]), Data: v.Value[5 : len+5]}
+}
+
+func (v RawValue) BinaryOK() (Binary, bool) {
+	if v.Type != BsonTypeBinary {
+		return Binary{}, false
+	}
+	return v.Binary(), true
+}
+
+func (v RawValue) ObjectId() ObjectId {
+	v.checkType(BsonTypeObjectId)
+	return ObjectId(v.Value[:12])
+}
+
+func (v RawValue) ObjectIdOK() (ObjectId, bool) {
+	if v.Type != BsonTypeObjectId {
+		return "", false
+	}
+	return ObjectId(v.Value[:12]), true
+}
+
+func (v RawValue) Bool() bool {
+	v.checkType(BsonTypeBool)
+	return v.Value[0] == 0x01
+}
+
+func (v RawValue) BoolOK() (bool, bool) {
+	if v.Type != BsonTypeBool {
+		return false, false
+	}
+	return v.Value[0] == 0x01, true
+}
+
+func (v RawValue) Date() Date {
+	v.checkType(BsonTypeDate)
+	return Date(bytesToInt64(v.Value))
+}
+
+func (v RawValue) DateOK() (Date, bool) {
+	if v.Type != BsonTypeDate {
+		return 0, false
+	}
+	return Date(bytesToInt64(v.Value)), true
+}
+
+func (v RawValue) IsNull() bool {
+	return v.Type == BsonTypeNull
+}
+
+func (v RawValue) RegEx() RegEx {
+	v.checkType(BsonTypeRegEx)
+	patternLen := cstringLength(v.Value)
+	optionsLen := cstringLength(v.Value[patternLen:])
+	return RegEx{Pattern: string(v.Value[:patternLen-1]), Options: string(v.Value[patternLen : patternLen+optionsLen-1])}
+}
+
+func (v RawValue) RegExOK() (RegEx, bool) {
+	if v.Type != BsonTypeRegEx {
+		return RegEx{}, false
+	}
+	return v.RegEx(), true
+}
+
+func (v RawValue) Int32() int32 {
+	v.checkType(BsonTypeInt32)
+	return bytesToInt32(v.Value)
+}
+
+func (v RawValue) Int32OK() (int32, bool) {
+	if v.Type != BsonTypeInt32 {
+		return 0, false
+	}
+	return bytesToInt32(v.Value), true
+}
+
+func (v RawValue) Timestamp() Timestamp {
+	v.checkType(BsonTypeTimestamp)
+	return Timestamp{Increment: bytesToInt32(v.Value), Second: bytesToInt32(v.Value[4:])}
+}
+
+func (v RawValue) TimestampOK() (Timestamp, bool) {
+	if v.Type != BsonTypeTimestamp {
+		return Timestamp{}, false
+	}
+	return v.Timestamp(), true
+}
+
+func (v RawValue) Int64() int64 {
+	v.checkType(BsonTypeInt64)
+	return bytesToInt64(v.Value)
+}
+
+func (v RawValue) Int64OK() (int64, bool) {
+	if v.Type != BsonTypeInt64 {
+		return 0, false
+	}
+	return bytesToInt64(v.Value), true
+}
+
+func (v RawValue) Decimal() Decimal {
+	v.checkType(BsonTypeDecimal)
+	return decodeDecimal(v.Value)
+}
+
+func (v RawValue) DecimalOK() (Decimal, bool) {
+	if v.Type != BsonTypeDecimal {
+		return Decimal{}, false
+	}
+	return decodeDecimal(v.Value), true
+}
+
+// Equal reports whether v and o have the same type and bytes.
+func (v RawValue) Equal(o RawValue) bool {
+	return v.Type == o.Type && bytes.Equal(v.Value, o.Value)
+}
+
+// Interface returns the value as BsonIterator.Value does.
+func (v RawValue) Interface() interface{} {
+	switch v.Type {
+	case BsonTypeFloat64:
+		return v.Float64()
+	case BsonTypeString:
+		return v.StringValue()
+	case BsonTypeBson:
+		return v.Document()
+	case BsonTypeArray:
+		return v.Array()
+	case BsonTypeBinary:
+		return v.Binary()
+	case BsonTypeObjectId:
+		return v.ObjectId()
+	case BsonTypeBool:
+		return v.Bool()
+	case BsonTypeDate:
+		return v.Date()
+	case BsonTypeNull:
+		return nil
+	case BsonTypeRegEx:
+		return v.RegEx()
+	case BsonTypeInt32:
+		return v.Int32()
+	case BsonTypeTimestamp:
+		return v.Timestamp()
+	case BsonTypeInt64:
+		return v.Int64()
+	case BsonTypeDecimal:
+		return v.Decimal()
+	case BsonTypeMaxKey:
+		return MaxKey
+	case BsonTypeMinKey:
+		return MinKey
+	default:
+		panic(fmt.Errorf("invalid bson type: %v", v.Type))
+	}
+}
+
+func (v RawValue) String() string {
+	switch v.Type {
+	case BsonTypeString:
+		return fmt.Sprintf(`"%s"`, v.StringValue())
+	case BsonTypeNull:
+		return "null"
+	default:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+}
+
+// RawElement is a bson element whose value references the bytes of its document.
+type RawElement struct {
+	Key   string
+	Type  BsonType
+	Value []byte
+}
+
+func (e RawElement) RawValue() RawValue {
+	return RawValue{Type: e.Type, Value: e.Value}
+}
+
+// Equal reports whether e and o have the same key, type and bytes.
+func (e RawElement) Equal(o RawElement) bool {
+	return e.Key == o.Key && e.RawValue().Equal(o.RawValue())
+}
+
+func (e RawElement) String() string {
+	return fmt.Sprintf(`"%s":%s`, e.Key, e.RawValue())
+}
+
+// RawValue returns the current value without copying.
+func (it *BsonIterator) RawValue() RawValue {
+	return RawValue{Type: it.BsonType(), Value: it.valueBytes()}
+}
+
+// RawElement returns the current element, the value is not copied.
+func (it *BsonIterator) RawElement() RawElement {
+	return RawElement{Key: it.Name(), Type: it.BsonType(), Value: it.valueBytes()}
+}
+
+// ErrElementNotFound is returned by Lookup and LookupPath if the path doesn't exist.
+var ErrElementNotFound = errors.New("element not found")
+
+// Lookup returns the value of the dotted path, e.g. "a.b.0.c",
+// where the numbers are the indexes of arrays.
+func (bson *Bson) Lookup(path string) (RawValue, error) {
+	raw := bson.raw
+	start := 0
+	for {
+		end := strings.IndexByte(path[start:], '.')
+		last := end < 0
+		if last {
+			end = len(path)
+		} else {
+			end += start
+		}
+
+		v, found := lookupKey(raw, path[start:end])
+		if err := checkLookup(path[:end], v, found, last); err != nil {
+			return RawValue{}, err
+		}
+		if last {
+			return v, nil
+		}
+		raw = v.Value
+		start = end + 1
+	}
+}
+
+// LookupPath returns the value of the path of keys without decoding the documents.
+// The returned value references the bytes of bson.
+func (bson *Bson) LookupPath(keys ...string) (RawValue, error) {
+	if len(keys) == 0 {
+		return RawValue{}, errors.New("empty lookup path")
+	}
+
+	raw := bson.raw
+	for i, key := range keys {
+		v, found := lookupKey(raw, key)
+		last := i == len(keys)-1
+		if !found || !last && v.Type != BsonTypeBson && v.Type != BsonTypeArray {
+			return RawValue{}, checkLookup(strings.Join(keys[:i+1], "."), v, found, last)
+		}
+		if last {
+			return v, nil
+		}
+		raw = v.Value
+	}
+	panic("unreachable")
+}
+
+// checkLookup returns the error if the value v of path isn't found,
+// or isn't the last one in the path and can't be looked into.
+func checkLookup(path string, v RawValue, found bool, last bool) error {
+	if !found {
+		return fmt.Errorf("%w: %q", ErrElementNotFound, path)
+	}
+	if !last && v.Type != BsonTypeBson && v.Type != BsonTypeArray {
+		return fmt.Errorf("can't lookup in %q: bson value of type %v is not a document or array", path, v.Type)
+	}
+	return nil
+}
+
+// lookupKey returns the value of key in the raw document, the other elements are skipped.
+func lookupKey(raw []byte, key string) (RawValue, bool) {
+	var it BsonIterator
+	it.init(raw)
+	for it.Next() {
+		if string(it.name()) == key {
+			return it.RawValue(), true
+		}
+	}
+	return RawValue{}, false
+}
@@ -0,0 +1,215 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+var lookupData = bson.Doc{
+	{"x", 1},
+	{"a", bson.Doc{
+		{"y", "skip"},
+		{"b", []interface{}{
+			bson.Doc{{"c", "first"}, {"d", 1.5}},
+			bson.Doc{{"c", int64(5000000000)}},
+		}},
+		{"n", nil},
+	}},
+	{"s", "str"},
+}.Bson()
+
+func TestLookup(t *testing.T) {
+	var tests = []struct {
+		path string
+		want string
+		typ  bson.BsonType
+	}{
+		{"x", "1", bson.BsonTypeInt32},
+		{"s", `"str"`, bson.BsonTypeString},
+		{"a.b.0.c", `"first"`, bson.BsonTypeString},
+		{"a.b.0.d", "1.5", bson.BsonTypeFloat64},
+		{"a.b.1.c", "5000000000", bson.BsonTypeInt64},
+		{"a.b.1", `{"c":5000000000}`, bson.BsonTypeBson},
+		{"a.b", `[{"c":"first", "d":1.5}, {"c":5000000000}]`, bson.BsonTypeArray},
+		{"a.n", "null", bson.BsonTypeNull},
+	}
+
+	for _, test := range tests {
+		v, err := lookupData.Lookup(test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if v.Type != test.typ || v.String() != test.want {
+			t.Errorf("%s: expected %v %s, actual %v %s", test.path, test.typ, test.want, v.Type, v)
+		}
+	}
+
+	v, err := lookupData.LookupPath("a", "b", "0", "c")
+	if err != nil || v.StringValue() != "first" {
+		t.Errorf("invalid LookupPath: %v, %v", v, err)
+	}
+	if v, err := lookupData.Lookup("a.b.1"); err != nil || v.Document().String() != `{"c":5000000000}` {
+		t.Errorf("invalid Document: %v, %v", v, err)
+	}
+	if v, err := lookupData.Lookup("a.b.1.c"); err != nil || v.Int64() != 5000000000 {
+		t.Errorf("invalid Int64: %v, %v", v, err)
+	}
+}
+
+func TestLookupError(t *testing.T) {
+	var tests = []struct {
+		path     string
+		notFound bool
+	}{
+		{"z", true},
+		{"a.z", true},
+		{"a.b.2", true},
+		{"a.b.0.c.d", false},
+		{"x.y", false},
+		{"a.n.z", false},
+		{"", true},
+	}
+
+	for _, test := range tests {
+		_, err := lookupData.Lookup(test.path)
+		if err == nil {
+			t.Errorf("%q: expected error", test.path)
+			continue
+		}
+		if errors.Is(err, bson.ErrElementNotFound) != test.notFound {
+			t.Errorf("%q: unexpected error %v", test.path, err)
+		}
+	}
+
+	if _, err := lookupData.LookupPath(); err == nil {
+		t.Errorf("expected error of empty path")
+	}
+}
+
+func TestRawValueTypeMismatch(t *testing.T) {
+	v, _ := lookupData.Lookup("x")
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	v.StringValue()
+}
+
+func BenchmarkSdbBsonLookup(t *testing.B) {
+	for i := 0; i < t.N; i++ {
+		lookupData.Lookup("a.b.1.c")
+	}
+}
+
+func TestRawValueOK(t *testing.T) {
+	v, _ := lookupData.Lookup("x")
+	if i, ok := v.Int32OK(); !ok || i != 1 {
+		t.Errorf("invalid Int32OK: %v, %v", i, ok)
+	}
+	if _, ok := v.StringValueOK(); ok {
+		t.Errorf("expected StringValueOK to fail")
+	}
+	if _, ok := v.DocumentOK(); ok {
+		t.Errorf("expected DocumentOK to fail")
+	}
+
+	v, _ = lookupData.Lookup("a")
+	if d, ok := v.DocumentOK(); !ok || d.String() != v.Document().String() {
+		t.Errorf("invalid DocumentOK: %v, %v", d, ok)
+	}
+	if _, ok := v.Int64OK(); ok {
+		t.Errorf("expected Int64OK to fail")
+	}
+}
+
+func TestRawElement(t *testing.T) {
+	b := bson.Doc{{"a", 1}, {"b", "x"}, {"c", 1}}.Bson()
+
+	var elems []bson.RawElement
+	it := b.Iterator()
+	for it.Next() {
+		elems = append(elems, it.RawElement())
+	}
+
+	if len(elems) != 3 || elems[1].Key != "b" || elems[1].Type != bson.BsonTypeString || elems[1].String() != `"b":"x"` {
+		t.Fatalf("invalid raw elements: %v", elems)
+	}
+	if !elems[0].RawValue().Equal(elems[2].RawValue()) || elems[0].RawValue().Equal(elems[1].RawValue()) {
+		t.Errorf("invalid RawValue.Equal")
+	}
+	if elems[0].Equal(elems[2]) || !elems[0].Equal(elems[0]) {
+		t.Errorf("invalid RawElement.Equal")
+	}
+
+	// re-key and forward the elements
+	builder := bson.NewBsonBuilder()
+	for _, e := range elems {
+		builder.AppendRaw("new_"+e.Key, e.RawValue())
+	}
+	builder.Append("any", elems[1].RawValue())
+	builder.AppendArrayStart("array").AppendRaw(elems[0].RawValue()).Finish().AppendArrayEnd()
+	builder.Finish()
+
+	want := `{"new_a":1, "new_b":"x", "new_c":1, "any":"x", "array":[1]}`
+	if builder.Bson().String() != want {
+		t.Errorf("expected: %s, actual: %s", want, builder.Bson())
+	}
+}
+
+func TestAppendInvalidRaw(t *testing.T) {
+	var tests = []bson.RawValue{
+		{Type: bson.BsonTypeInt32, Value: []byte{1, 2}},
+		{Type: bson.BsonTypeString, Value: []byte{5, 0, 0, 0, 'a', 0}},
+		{Type: bson.BsonTypeEOD},
+		{Type: bson.BsonType(0x30)},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%v: expected panic", test)
+				}
+			}()
+			bson.NewBsonBuilder().AppendRaw("v", test)
+		}()
+	}
+}
+
+func TestRawValueStruct(t *testing.T) {
+	type st struct {
+		A bson.RawValue `bson:"a"`
+		B bson.RawValue
+	}
+
+	var s st
+	lookupData.Struct(&s)
+	if s.B.Type != bson.BsonTypeEOD {
+		t.Errorf("unexpected value: %v", s.B)
+	}
+	s.B, _ = lookupData.Lookup("a.b.0.c")
+
+	b := bson.StructToBson(&s)
+	want := `{"a":{"y":"skip", "b":[{"c":"first", "d":1.5}, {"c":5000000000}], "n":null}, "B":"first"}`
+	if b.String() != want {
+		t.Errorf("expected: %s, actual: %s", want, b)
+	}
+}
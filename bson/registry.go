@@ -0,0 +1,164 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ValueEncoder appends v to b as the element named name.
+type ValueEncoder func(b *BsonBuilder, name string, v reflect.Value)
+
+// ValueDecoder sets v to the current value of it. v is always settable.
+type ValueDecoder func(v reflect.Value, it *BsonIterator)
+
+// Registry holds the encoders and decoders of Go types or kinds which
+// replace the builtin conversions. Encoders and decoders of types take
+// precedence over those of kinds, and those of kinds don't apply to the types
+// of this package, e.g. Doc and Map. Values which have no registered encoder
+// or decoder are converted as usual.
+//
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu           sync.RWMutex
+	n            int32 // number of registered encoders and decoders
+	typeEncoders map[reflect.Type]ValueEncoder
+	kindEncoders map[reflect.Kind]ValueEncoder
+	typeDecoders map[reflect.Type]ValueDecoder
+	kindDecoders map[reflect.Kind]ValueDecoder
+}
+
+// DefaultRegistry is used by the builders and decoders which are not given a registry.
+// It is empty, so the builtin conversions are used for all types.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		typeEncoders: map[reflect.Type]ValueEncoder{},
+		kindEncoders: map[reflect.Kind]ValueEncoder{},
+		typeDecoders: map[reflect.Type]ValueDecoder{},
+		kindDecoders: map[reflect.Kind]ValueDecoder{},
+	}
+}
+
+func (r *Registry) RegisterTypeEncoder(t reflect.Type, enc ValueEncoder) *Registry {
+	r.mu.Lock()
+	r.typeEncoders[t] = enc
+	r.mu.Unlock()
+	atomic.AddInt32(&r.n, 1)
+	return r
+}
+
+func (r *Registry) RegisterKindEncoder(k reflect.Kind, enc ValueEncoder) *Registry {
+	r.mu.Lock()
+	r.kindEncoders[k] = enc
+	r.mu.Unlock()
+	atomic.AddInt32(&r.n, 1)
+	return r
+}
+
+func (r *Registry) RegisterTypeDecoder(t reflect.Type, dec ValueDecoder) *Registry {
+	r.mu.Lock()
+	r.typeDecoders[t] = dec
+	r.mu.Unlock()
+	atomic.AddInt32(&r.n, 1)
+	return r
+}
+
+func (r *Registry) RegisterKindDecoder(k reflect.Kind, dec ValueDecoder) *Registry {
+	r.mu.Lock()
+	r.kindDecoders[k] = dec
+	r.mu.Unlock()
+	atomic.AddInt32(&r.n, 1)
+	return r
+}
+
+// LookupEncoder returns the encoder registered for type t, or its kind if t isn't of this package.
+func (r *Registry) LookupEncoder(t reflect.Type) (ValueEncoder, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if enc, ok := r.typeEncoders[t]; ok {
+		return enc, true
+	}
+	if isBsonType(t) {
+		return nil, false
+	}
+	enc, ok := r.kindEncoders[t.Kind()]
+	return enc, ok
+}
+
+// LookupDecoder returns the decoder registered for type t, or its kind if t isn't of this package.
+func (r *Registry) LookupDecoder(t reflect.Type) (ValueDecoder, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if dec, ok := r.typeDecoders[t]; ok {
+		return dec, true
+	}
+	if isBsonType(t) {
+		return nil, false
+	}
+	dec, ok := r.kindDecoders[t.Kind()]
+	return dec, ok
+}
+
+var bsonPkgPath = reflect.TypeOf(Doc{}).PkgPath()
+
+// isBsonType reports whether t, or the type t points to, is defined in this package.
+// They have the builtin conversions, e.g. Doc is a document rather than a slice.
+func isBsonType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() == bsonPkgPath
+}
+
+// active returns nil if nothing is registered in r,
+// so that the callers can skip the lookups.
+func (r *Registry) active() *Registry {
+	if r == nil || atomic.LoadInt32(&r.n) == 0 {
+		return nil
+	}
+	return r
+}
+
+// encode calls the encoder registered for the type of v if any,
+// and reports whether it is called.
+func (r *Registry) encode(b *BsonBuilder, name string, v reflect.Value) bool {
+	if r = r.active(); r == nil {
+		return false
+	}
+	enc, ok := r.LookupEncoder(v.Type())
+	if !ok {
+		return false
+	}
+	enc(b, name, v)
+	return true
+}
+
+// decode calls the decoder registered for the type of v if any,
+// and reports whether it is called.
+func (r *Registry) decode(v reflect.Value, it *BsonIterator) bool {
+	if r = r.active(); r == nil {
+		return false
+	}
+	dec, ok := r.LookupDecoder(v.Type())
+	if !ok {
+		return false
+	}
+	dec(v, it)
+	return true
+}
@@ -0,0 +1,223 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func newTestRegistry() *bson.Registry {
+	r := bson.NewRegistry()
+
+	// time.Duration as int64 nanoseconds
+	r.RegisterTypeEncoder(reflect.TypeOf(time.Duration(0)), func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		b.AppendInt64(name, v.Int())
+	})
+	r.RegisterTypeDecoder(reflect.TypeOf(time.Duration(0)), func(v reflect.Value, it *bson.BsonIterator) {
+		switch it.BsonType() {
+		case bson.BsonTypeInt64:
+			v.SetInt(it.Int64())
+		case bson.BsonTypeInt32:
+			v.SetInt(int64(it.Int32()))
+		}
+	})
+
+	// net.IP as string
+	r.RegisterTypeEncoder(reflect.TypeOf(net.IP{}), func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		if v.IsNil() {
+			b.AppendNull(name)
+		} else {
+			b.AppendString(name, v.Interface().(net.IP).String())
+		}
+	})
+	r.RegisterTypeDecoder(reflect.TypeOf(net.IP{}), func(v reflect.Value, it *bson.BsonIterator) {
+		if it.BsonType() == bson.BsonTypeString {
+			v.Set(reflect.ValueOf(net.ParseIP(it.UTF8String())))
+		}
+	})
+
+	// *big.Int as decimal
+	r.RegisterTypeEncoder(reflect.TypeOf((*big.Int)(nil)), func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		if v.IsNil() {
+			b.AppendNull(name)
+		} else {
+			b.AppendDecimal(name, bson.Decimal{Value: v.Interface().(*big.Int).String()})
+		}
+	})
+	r.RegisterTypeDecoder(reflect.TypeOf((*big.Int)(nil)), func(v reflect.Value, it *bson.BsonIterator) {
+		if it.BsonType() == bson.BsonTypeDecimal {
+			i, _ := new(big.Int).SetString(it.Decimal().Value, 10)
+			v.Set(reflect.ValueOf(i))
+		}
+	})
+
+	// bool as int32 0 or 1
+	r.RegisterKindEncoder(reflect.Bool, func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		if v.Bool() {
+			b.AppendInt32(name, 1)
+		} else {
+			b.AppendInt32(name, 0)
+		}
+	})
+	r.RegisterKindDecoder(reflect.Bool, func(v reflect.Value, it *bson.BsonIterator) {
+		v.SetBool(it.Int32() != 0)
+	})
+
+	return r
+}
+
+type registryStruct struct {
+	Timeout  time.Duration
+	Timeouts []time.Duration
+	IP       net.IP
+	Big      *big.Int
+	Flag     bool
+	Inner    struct{ Flag bool }
+	Any      interface{}
+}
+
+func TestRegistryStruct(t *testing.T) {
+	s := registryStruct{
+		Timeout:  time.Second,
+		Timeouts: []time.Duration{time.Millisecond},
+		IP:       net.ParseIP("192.168.1.1"),
+		Big:      new(big.Int).Lsh(big.NewInt(1), 100),
+		Flag:     true,
+		Any:      time.Duration(2),
+	}
+	s.Inner.Flag = true
+
+	r := newTestRegistry()
+	b := bson.StructToBsonWithRegistry(&s, r)
+	want := bson.NewBsonBuilder().
+		AppendInt64("Timeout", int64(time.Second)).
+		AppendArrayStart("Timeouts").
+		AppendInt64(int64(time.Millisecond)).
+		Finish().
+		AppendArrayEnd().
+		AppendString("IP", "192.168.1.1").
+		AppendDecimal("Big", bson.Decimal{Value: "1267650600228229401496703205376"}).
+		AppendInt32("Flag", 1).
+		AppendBsonStart("Inner").
+		AppendInt32("Flag", 1).
+		Finish().
+		AppendBsonEnd().
+		AppendInt64("Any", 2).
+		Finish().
+		Bson()
+	if !bytes.Equal(b.Raw(), want.Raw()) {
+		t.Errorf("expected: %s\n  actual: %s", want, b)
+	}
+
+	var s2 registryStruct
+	b.StructWithRegistry(&s2, r)
+	s2.Any = s.Any // decoded as int64
+	if !reflect.DeepEqual(s, s2) {
+		t.Errorf("expected: %v\n  actual: %v", s, s2)
+	}
+}
+
+func TestRegistryBuilder(t *testing.T) {
+	r := newTestRegistry()
+	b := bson.NewBsonBuilder().SetRegistry(r)
+	b.Append("d", time.Duration(5))
+	b.Append("doc", bson.Doc{{"flag", false}})
+	b.Append("array", []interface{}{true})
+	b.Finish()
+
+	want := `{"d":5, "doc":{"flag":0}, "array":[1]}`
+	if b.Bson().String() != want {
+		t.Errorf("expected: %s, actual: %s", want, b.Bson())
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	type S struct {
+		Flag bool
+		N    int64
+	}
+	s := S{Flag: true, N: 10}
+	b := bson.StructToBson(&s)
+	b2 := bson.StructToBsonWithRegistry(&s, bson.NewRegistry())
+	if !bytes.Equal(b.Raw(), b2.Raw()) {
+		t.Errorf("expected: %s\n  actual: %s", b, b2)
+	}
+
+	var s2 S
+	b.StructWithRegistry(&s2, bson.DefaultRegistry)
+	if s2 != s {
+		t.Errorf("expected: %v\n  actual: %v", s, s2)
+	}
+}
+
+func TestRegistryKindBsonTypes(t *testing.T) {
+	// the kind encoders and decoders don't apply to Doc, Map, ObjectId and so on
+	r := bson.NewRegistry()
+	r.RegisterKindEncoder(reflect.Slice, func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		b.AppendInt32(name, int32(v.Len()))
+	})
+	r.RegisterKindEncoder(reflect.Map, func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		b.AppendInt32(name, int32(v.Len()))
+	})
+	r.RegisterKindEncoder(reflect.String, func(b *bson.BsonBuilder, name string, v reflect.Value) {
+		b.AppendString(name, "s:"+v.String())
+	})
+	r.RegisterKindDecoder(reflect.Slice, func(v reflect.Value, it *bson.BsonIterator) {})
+	r.RegisterKindDecoder(reflect.String, func(v reflect.Value, it *bson.BsonIterator) {})
+
+	type S struct {
+		Doc  bson.Doc
+		Map  bson.Map
+		Id   bson.ObjectId
+		Bson *bson.Bson
+		Ints []int
+		Name string
+	}
+	id := bson.NewObjectId()
+	s := S{
+		Doc:  bson.Doc{{"a", 1}},
+		Map:  bson.Map{"b": 2},
+		Id:   id,
+		Bson: bson.Doc{{"c", 3}}.Bson(),
+		Ints: []int{1, 2},
+		Name: "x",
+	}
+	b := bson.StructToBsonWithRegistry(&s, r)
+	want := bson.Doc{
+		{"Doc", bson.Doc{{"a", 1}}},
+		{"Map", bson.Doc{{"b", 2}}},
+		{"Id", id},
+		{"Bson", bson.Doc{{"c", 3}}},
+		{"Ints", 2},
+		{"Name", "s:x"},
+	}.Bson()
+	if !bytes.Equal(b.Raw(), want.Raw()) {
+		t.Errorf("expected: %s\n  actual: %s", want, b)
+	}
+
+	var s2 S
+	b.StructWithRegistry(&s2, r)
+	if s2.Doc.Bson().String() != s.Doc.Bson().String() || s2.Map["b"] != int32(2) || s2.Id != id || s2.Bson.String() != s.Bson.String() ||
+		s2.Ints != nil || s2.Name != "" {
+		t.Errorf("unexpected struct: %+v", s2)
+	}
+}
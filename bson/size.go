@@ -0,0 +1,167 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"math"
+	"time"
+)
+
+// The exact size of the bson of a Doc or Map is computed before building it,
+// so that the buffer is allocated only once. The size is -1 if it can't be
+// known without encoding, e.g. of structs, decimals, or if a registry is used,
+// and then the buffer grows as usual.
+
+func docSize(d Doc) int {
+	if DefaultRegistry.active() != nil {
+		return -1
+	}
+	return docElementsSize(d)
+}
+
+func mapSize(m Map) int {
+	if DefaultRegistry.active() != nil {
+		return -1
+	}
+	return mapElementsSize(m)
+}
+
+func docElementsSize(d Doc) int {
+	size := 4 + 1 // length and eod
+	for _, e := range d {
+		n := valueSize(e.Value)
+		if n < 0 {
+			return -1
+		}
+		size += 1 + len(e.Name) + 1 + n
+	}
+	return size
+}
+
+func mapElementsSize(m Map) int {
+	size := 4 + 1 // length and eod
+	for name, v := range m {
+		n := valueSize(v)
+		if n < 0 {
+			return -1
+		}
+		size += 1 + len(name) + 1 + n
+	}
+	return size
+}
+
+func arraySize(a []interface{}) int {
+	size := 4 + 1 // length and eod
+	for i, v := range a {
+		n := valueSize(v)
+		if n < 0 {
+			return -1
+		}
+		size += 1 + len(itoa(i)) + 1 + n
+	}
+	return size
+}
+
+// valueSize returns the size of the value part of the element of v as appended by Append.
+func valueSize(value interface{}) int {
+	switch v := value.(type) {
+	case float32, float64:
+		return 8
+	case int8, int16, int32, uint8, uint16:
+		return 4
+	case int64:
+		return intSize(v)
+	case int:
+		return intSize(int64(v))
+	case uint32:
+		if int32(v) < 0 {
+			return 8
+		}
+		return 4
+	case uint64:
+		return uintSize(v)
+	case uint:
+		return uintSize(uint64(v))
+	case uintptr:
+		return uintSize(uint64(v))
+	case bool:
+		return 1
+	case string:
+		return 4 + len(v) + 1
+	case nil:
+		return 0
+	case ObjectId:
+		if !v.IsValid() {
+			return -1
+		}
+		return 12
+	case Date, time.Time, Timestamp:
+		return 8
+	case RegEx:
+		return len(v.Pattern) + 1 + len(v.Options) + 1
+	case Binary:
+		if v.Data == nil {
+			return -1
+		}
+		return 4 + 1 + len(v.Data)
+	case orderKey:
+		if v != MaxKey && v != MinKey {
+			return -1
+		}
+		return 0
+	case Map:
+		return mapElementsSize(v)
+	case map[string]interface{}:
+		return mapElementsSize(v)
+	case map[string]string:
+		size := 4 + 1 // length and eod
+		for name, s := range v {
+			size += 1 + len(name) + 1 + 4 + len(s) + 1
+		}
+		return size
+	case Doc:
+		return docElementsSize(v)
+	case []interface{}:
+		return arraySize(v)
+	case *Bson:
+		if v == nil {
+			return 0
+		}
+		return len(v.Raw())
+	case *BsonArray:
+		if v == nil {
+			return 0
+		}
+		return len(v.Raw())
+	case RawValue:
+		return len(v.Value)
+	default:
+		return -1
+	}
+}
+
+func intSize(v int64) int {
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		return 4
+	}
+	return 8
+}
+
+func uintSize(v uint64) int {
+	if v > math.MaxInt64 {
+		return -1
+	}
+	return intSize(int64(v))
+}
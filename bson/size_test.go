@@ -0,0 +1,82 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestExactSize(t *testing.T) {
+	var tests = []bson.Doc{
+		{},
+		ddata,
+		{
+			{"int", 1},
+			{"int64", int64(math.MaxInt64)},
+			{"uint32", uint32(math.MaxUint32)},
+			{"uint", uint(1)},
+			{"nil", nil},
+			{"id", bson.NewObjectId()},
+			{"date", bson.Date(1)},
+			{"time", time.Unix(1, 0)},
+			{"regex", bson.RegEx{Pattern: "^a", Options: "i"}},
+			{"ts", bson.Timestamp{Increment: 1, Second: 2}},
+			{"binary", bson.Binary{Subtype: bson.BinaryTypeGeneral, Data: []byte("abc")}},
+			{"max", bson.MaxKey},
+			{"min", bson.MinKey},
+		},
+		{
+			{"doc", bson.Doc{{"a", "b"}, {"map", bson.Map{"c": 1.5}}}},
+			{"array", []interface{}{1, "2", bson.Doc{{"3", 4}}, []interface{}{}}},
+			{"bson", bson.Doc{{"a", 1}}.Bson()},
+			{"nilbson", (*bson.Bson)(nil)},
+			{"raw", bson.RawValue{Type: bson.BsonTypeInt32, Value: []byte{1, 0, 0, 0}}},
+		},
+	}
+
+	for i, test := range tests {
+		raw := test.Bson().Raw()
+		if cap(raw) != len(raw) {
+			t.Errorf("case %d: expected capacity %d, actual %d", i, len(raw), cap(raw))
+		}
+
+		raw = test.Map().Bson().Raw()
+		if cap(raw) != len(raw) {
+			t.Errorf("case %d: expected capacity %d of map, actual %d", i, len(raw), cap(raw))
+		}
+	}
+}
+
+func TestUnknownSize(t *testing.T) {
+	type st struct {
+		A int
+	}
+	var tests = []bson.Doc{
+		{{"struct", st{1}}},
+		{{"decimal", bson.Decimal{Value: "1.5"}}},
+		{{"doc", bson.Doc{{"strings", []string{"a"}}}}},
+	}
+
+	for i, test := range tests {
+		b := bson.NewBsonBuilder().AppendDoc(test).Finish().Bson()
+		if b2 := test.Bson(); b2.String() != b.String() {
+			t.Errorf("case %d: expected %s, actual %s", i, b, b2)
+		}
+	}
+}
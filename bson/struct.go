@@ -15,88 +15,124 @@
 package bson
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 )
 
-type structInfo struct {
-	Fields []fieldInfo
-}
-
-type fieldInfo struct {
-	Name  string
-	Index int
-}
-
 func structToBsonBuilder(s reflect.Value, b *BsonBuilder) {
-	t := s.Type()
-	n := s.NumField()
-	for i := 0; i < n; i++ {
-		field := t.Field(i)
-		// private field
-		if field.PkgPath != "" && !field.Anonymous {
+	info := getStructInfo(s.Type())
+	r := b.getRegistry().active()
+	for _, f := range info.Fields {
+		v := fieldByIndex(s, f.Index, false)
+		if !v.IsValid() {
+			// in a nil embedded struct pointer
+			continue
+		}
+		if f.OmitEmpty && isZero(v) {
 			continue
 		}
-		b.Append(field.Name, s.Field(i).Interface())
+		if r != nil && r.encode(b, f.Name, v) {
+			continue
+		}
+		f.encode(b, f.Name, v)
+	}
+
+	if info.InlineMap != nil {
+		m := fieldByIndex(s, info.InlineMap, false)
+		if !m.IsValid() {
+			return
+		}
+		elems, err := b.mapElements(m)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		for _, e := range elems {
+			if _, exist := info.FieldsMap[e.Name]; exist {
+				b.fail(fmt.Sprintf("duplicated key %q in inline map of struct %s", e.Name, s.Type()))
+				return
+			}
+			b.Append(e.Name, e.Value)
+		}
 	}
 }
 
 func StructToBson(s interface{}) *Bson {
-	v := reflect.ValueOf(s)
-	switch v.Kind() {
-	case reflect.Struct:
-	case reflect.Ptr:
-		if v.Elem().Kind() != reflect.Struct {
-			panic("s must be struct or struct pointer")
-		}
-		v = v.Elem()
-	default:
-		panic("s must be struct or struct pointer")
+	return StructToBsonWithRegistry(s, nil)
+}
+
+// StructToBsonWithRegistry is like StructToBson but uses the encoders
+// registered in r, or DefaultRegistry if r is nil.
+func StructToBsonWithRegistry(s interface{}, r *Registry) *Bson {
+	v, err := structValue(s)
+	if err != nil {
+		panic(err)
 	}
 
-	b := NewBsonBuilder()
+	info := getStructInfo(v.Type())
+	b := NewBsonBuilderSize(info.sizeHint()).SetRegistry(r)
 	structToBsonBuilder(v, b)
 	b.Finish()
+	info.updateSize(len(b.Raw()))
 	return b.Bson()
 }
 
-func mapToStruct(s reflect.Value, m Map) {
-	t := s.Type()
-	n := s.NumField()
-	for i := 0; i < n; i++ {
-		field := t.Field(i)
-		// private field
-		if field.PkgPath != "" && !field.Anonymous {
-			continue
-		}
+// StructToBsonE is like StructToBson but returns the error instead of panicking.
+func StructToBsonE(s interface{}) (*Bson, error) {
+	v, err := structValue(s)
+	if err != nil {
+		return nil, err
+	}
+	return buildE(func(b *BsonBuilder) {
+		structToBsonBuilder(v, b)
+	})
+}
 
-		v, exist := m[field.Name]
-		if exist {
-			setFieldValue(s.Field(i), v)
+// structValue returns the struct value of s, which is a struct or struct pointer.
+func structValue(s interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(s)
+	switch v.Kind() {
+	case reflect.Struct:
+		return v, nil
+	case reflect.Ptr:
+		if v.Elem().Kind() == reflect.Struct {
+			return v.Elem(), nil
 		}
 	}
+	return reflect.Value{}, errors.New("s must be struct or struct pointer")
 }
 
-func docToStruct(s reflect.Value, d Doc) {
-	t := s.Type()
-	n := s.NumField()
-
-	m := map[string]int{}
-	for idx, v := range d {
-		m[v.Name] = idx
+// setStructField sets the field named name of struct s,
+// or puts the value into the inline map if there is no such field.
+func setStructField(s reflect.Value, info *structInfo, name string, v interface{}) {
+	if f, exist := info.FieldsMap[name]; exist {
+		setFieldValue(fieldByIndex(s, f.Index, true), v)
+		return
 	}
 
-	for i := 0; i < n; i++ {
-		field := t.Field(i)
-		// private field
-		if field.PkgPath != "" && !field.Anonymous {
-			continue
+	if info.InlineMap != nil {
+		m := fieldByIndex(s, info.InlineMap, true)
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(m.Type()))
 		}
+		ev := reflect.New(m.Type().Elem()).Elem()
+		setFieldValue(ev, v)
+		m.SetMapIndex(reflect.ValueOf(name).Convert(m.Type().Key()), ev)
+	}
+}
 
-		idx, exist := m[field.Name]
-		if exist {
-			setFieldValue(s.Field(i), d[idx].Value)
-		}
+func mapToStruct(s reflect.Value, m Map) {
+	info := getStructInfo(s.Type())
+	for name, v := range m {
+		setStructField(s, info, name, v)
+	}
+}
+
+func docToStruct(s reflect.Value, d Doc) {
+	info := getStructInfo(s.Type())
+	for _, e := range d {
+		setStructField(s, info, e.Name, e.Value)
 	}
 }
 
@@ -111,10 +147,24 @@ func tryConvert(in reflect.Value, t reflect.Type) (out reflect.Value, err error)
 }
 
 func setFieldValue(f reflect.Value, v interface{}) {
+	if f.Type() == typeTime {
+		switch v := v.(type) {
+		case Date:
+			f.Set(reflect.ValueOf(v.Time()))
+		case Timestamp:
+			f.Set(reflect.ValueOf(v.Time()))
+		}
+		return
+	}
+
 	value := reflect.ValueOf(v)
 	switch f.Kind() {
 	case reflect.Interface:
-		f.Set(value)
+		if v == nil {
+			f.Set(reflect.Zero(f.Type()))
+		} else {
+			f.Set(value)
+		}
 	case reflect.String:
 		switch value.Kind() {
 		case reflect.String:
@@ -254,7 +304,11 @@ func setFieldValue(f reflect.Value, v interface{}) {
 			panic("no uint types in Bson")
 		}
 	case reflect.Ptr:
-		if v != nil && f.IsNil() {
+		if v == nil {
+			f.Set(reflect.Zero(f.Type()))
+			return
+		}
+		if f.IsNil() {
 			f.Set(reflect.New(f.Type().Elem()))
 		}
 
@@ -273,9 +327,7 @@ func setFieldValue(f reflect.Value, v interface{}) {
 			}
 		}
 	case reflect.Map:
-		if f.Type().Key().Kind() != reflect.String {
-			panic("the key of map must be string")
-		}
+		kt := f.Type().Key()
 		switch value.Kind() {
 		case reflect.Map:
 			if f.IsNil() {
@@ -283,7 +335,7 @@ func setFieldValue(f reflect.Value, v interface{}) {
 			}
 			if f.Type().Elem() == value.Type().Elem() {
 				for _, k := range value.MapKeys() {
-					f.SetMapIndex(k, value.MapIndex(k))
+					f.SetMapIndex(mustParseMapKey(k.String(), kt), value.MapIndex(k))
 				}
 			} else {
 				for _, k := range value.MapKeys() {
@@ -292,7 +344,7 @@ func setFieldValue(f reflect.Value, v interface{}) {
 						mv = mv.Elem()
 					}
 					if nv, err := tryConvert(mv, f.Type().Elem()); err == nil {
-						f.SetMapIndex(k, nv)
+						f.SetMapIndex(mustParseMapKey(k.String(), kt), nv)
 					}
 				}
 			}
@@ -306,7 +358,7 @@ func setFieldValue(f reflect.Value, v interface{}) {
 			}
 			if f.Type().Elem() == value.Type().Elem() {
 				for _, ev := range d {
-					f.SetMapIndex(reflect.ValueOf(ev.Name), reflect.ValueOf(ev.Value))
+					f.SetMapIndex(mustParseMapKey(ev.Name, kt), reflect.ValueOf(ev.Value))
 				}
 			} else {
 				for _, ev := range d {
@@ -315,7 +367,7 @@ func setFieldValue(f reflect.Value, v interface{}) {
 						mv = mv.Elem()
 					}
 					if nv, err := tryConvert(mv, f.Type().Elem()); err == nil {
-						f.SetMapIndex(reflect.ValueOf(ev.Name), nv)
+						f.SetMapIndex(mustParseMapKey(ev.Name, kt), nv)
 					}
 				}
 			}
@@ -21,6 +21,10 @@ import (
 
 	"reflect"
 
+	"sync"
+
+	"time"
+
 	"github.com/davidli2010/gobson_exp/bson"
 )
 
@@ -1049,6 +1053,653 @@ func TestArraySliceDocStruct(t *testing.T) {
 	}
 }
 
+func TestTagStruct(t *testing.T) {
+	type st struct {
+		Name    string `bson:"name"`
+		Skip    string `bson:"-"`
+		Empty   string `bson:",omitempty"`
+		Zero    int    `bson:"zero,omitempty"`
+		Long    int64  `bson:"long"`
+		Short   int64  `bson:"short,minsize"`
+		NoSlice []int  `bson:"noslice,omitempty"`
+		Slice   []int  `bson:"slice,omitempty"`
+	}
+
+	s := st{Name: "a", Skip: "b", Long: 1, Short: 2, Slice: []int{1}}
+	b := bson.StructToBson(s)
+
+	expected := `{"name":"a", "long":1, "short":2, "slice":[1]}`
+	if b.String() != expected {
+		t.Errorf("expected: %s, actual: %s", expected, b.String())
+	}
+
+	// like Append, an int64 that fits in int32 is stored as int32 with or without minsize
+	it := b.Iterator()
+	for it.Next() {
+		if (it.Name() == "long" || it.Name() == "short") && it.BsonType() != bson.BsonTypeInt32 {
+			t.Errorf("int64 field %s must be stored as int32, actual %v", it.Name(), it.BsonType())
+		}
+	}
+	if !b.Equal(bson.Doc{{"name", "a"}, {"long", int64(1)}, {"short", int64(2)}, {"slice", []int{1}}}.Bson(), false) {
+		t.Errorf("expected the same bytes as Doc")
+	}
+	b = bson.StructToBson(st{Long: 1 << 40, Short: 1 << 40})
+	it = b.Iterator()
+	for it.Next() {
+		if (it.Name() == "long" || it.Name() == "short") && it.BsonType() != bson.BsonTypeInt64 {
+			t.Errorf("large int64 field %s must be stored as int64, actual %v", it.Name(), it.BsonType())
+		}
+	}
+
+	var s2 st
+	bson.Doc{{"name", "a"}, {"Skip", "b"}, {"Empty", "c"}, {"long", 1}, {"short", 2}, {"slice", []int{1}}}.Bson().Struct(&s2)
+	if s2.Name != "a" || s2.Skip != "" || s2.Empty != "c" || s2.Long != 1 || s2.Short != 2 ||
+		len(s2.Slice) != 1 || s2.Slice[0] != 1 {
+		t.Errorf("invalid tag struct mapping: %+v", s2)
+	}
+}
+
+func TestIntStruct(t *testing.T) {
+	// the fields without tags, stored as int32 if the values fit
+	type st struct {
+		I64 int64
+		U64 uint64
+		I   int
+		U32 uint32
+	}
+
+	var tests = []struct {
+		s    st
+		want bson.BsonType
+	}{
+		{st{I64: 1, U64: 2, I: 3, U32: 4}, bson.BsonTypeInt32},
+		{st{I64: -1 << 31, U64: 1<<31 - 1, I: 1<<31 - 1, U32: 0}, bson.BsonTypeInt32},
+		{st{I64: 1 << 40, U64: 1 << 31, I: -1<<31 - 1, U32: 1 << 31}, bson.BsonTypeInt64},
+	}
+
+	for _, test := range tests {
+		b := bson.StructToBson(test.s)
+		it := b.Iterator()
+		for it.Next() {
+			if it.BsonType() != test.want {
+				t.Errorf("%+v: field %s must be stored as %v, actual %v", test.s, it.Name(), test.want, it.BsonType())
+			}
+		}
+
+		var s2 st
+		b.Struct(&s2)
+		if s2 != test.s {
+			t.Errorf("expected: %+v, actual: %+v", test.s, s2)
+		}
+	}
+}
+
+func TestInlineStruct(t *testing.T) {
+	type inner struct {
+		A int    `bson:"a"`
+		B string `bson:"b,omitempty"`
+	}
+
+	type st struct {
+		Inner inner                  `bson:",inline"`
+		C     int                    `bson:"c"`
+		Extra map[string]interface{} `bson:",inline"`
+	}
+
+	s := st{Inner: inner{A: 1}, C: 2, Extra: map[string]interface{}{"d": "x"}}
+	b := bson.StructToBson(s)
+
+	expected := `{"a":1, "c":2, "d":"x"}`
+	if b.String() != expected {
+		t.Errorf("expected: %s, actual: %s", expected, b.String())
+	}
+
+	var s2 st
+	bson.Doc{{"a", 1}, {"b", "y"}, {"c", 2}, {"d", "x"}, {"e", nil}}.Bson().Struct(&s2)
+	if s2.Inner.A != 1 || s2.Inner.B != "y" || s2.C != 2 {
+		t.Errorf("invalid inline struct mapping: %+v", s2)
+	}
+	if len(s2.Extra) != 2 || s2.Extra["d"] != "x" || s2.Extra["e"] != nil {
+		t.Errorf("invalid inline map mapping: %+v", s2.Extra)
+	}
+
+	type typed struct {
+		A     int            `bson:"a"`
+		Extra map[string]int `bson:",inline"`
+	}
+
+	var s3 typed
+	bson.Map{"a": 1, "b": int64(2), "c": 3.0}.Bson().Struct(&s3)
+	if s3.A != 1 || len(s3.Extra) != 2 || s3.Extra["b"] != 2 || s3.Extra["c"] != 3 {
+		t.Errorf("invalid typed inline map mapping: %+v", s3)
+	}
+}
+
+func TestInvalidTagStruct(t *testing.T) {
+	type dup struct {
+		A int `bson:"a"`
+		B int `bson:"a"`
+	}
+
+	type inner struct {
+		A int `bson:"a"`
+	}
+
+	type inlineDup struct {
+		A     int   `bson:"a"`
+		Inner inner `bson:",inline"`
+	}
+
+	type inlinePtr struct {
+		Inner *inner `bson:",inline"`
+	}
+
+	type inlineMapDup struct {
+		A     int            `bson:"a"`
+		Extra map[string]int `bson:",inline"`
+	}
+
+	var tests = []interface{}{
+		dup{},
+		inlineDup{},
+		inlinePtr{},
+		inlineMapDup{Extra: map[string]int{"a": 1}},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%T: expected panic", test)
+				}
+			}()
+			bson.StructToBson(test)
+		}()
+	}
+}
+
+func TestBsonValueStruct(t *testing.T) {
+	type st struct {
+		Bson      *bson.Bson
+		Array     *bson.BsonArray
+		Binary    bson.Binary
+		ObjectId  bson.ObjectId
+		Date      bson.Date
+		RegEx     bson.RegEx
+		Timestamp bson.Timestamp
+		Decimal   bson.Decimal
+		Null      interface{}
+		Nested    []map[string][]int
+	}
+
+	s := st{
+		Bson:      bson.Doc{{"a", 1}}.Bson(),
+		Array:     bson.NewBsonArrayBuilder().AppendInt32(1).Finish().BsonArray(),
+		Binary:    bson.Binary{Subtype: bson.BinaryTypeMD5, Data: []byte("md5")},
+		ObjectId:  bson.NewObjectId(),
+		Date:      bson.Date(12345),
+		RegEx:     bson.RegEx{Pattern: "^a", Options: "i"},
+		Timestamp: bson.Timestamp{Second: 1, Increment: 2},
+		Decimal:   bson.Decimal{Value: "1.5"},
+		Nested:    []map[string][]int{{"a": {1, 2}}, {"b": {3}}},
+	}
+
+	b := bson.StructToBson(s)
+
+	s2 := st{Null: "not null"}
+	b.Struct(&s2)
+
+	if s2.Bson == nil || s2.Bson.String() != s.Bson.String() {
+		t.Errorf("invalid bson field mapping: %v", s2.Bson)
+	}
+	if s2.Array == nil || s2.Array.String() != s.Array.String() {
+		t.Errorf("invalid array field mapping: %v", s2.Array)
+	}
+	if s2.Binary.Subtype != s.Binary.Subtype || string(s2.Binary.Data) != string(s.Binary.Data) {
+		t.Errorf("invalid binary field mapping: %v", s2.Binary)
+	}
+	if s2.ObjectId != s.ObjectId || s2.Date != s.Date || s2.RegEx != s.RegEx ||
+		s2.Timestamp != s.Timestamp || s2.Decimal != s.Decimal {
+		t.Errorf("invalid bson value field mapping: %+v", s2)
+	}
+	if s2.Null != nil {
+		t.Errorf("invalid null field mapping: %v", s2.Null)
+	}
+	if len(s2.Nested) != 2 || len(s2.Nested[0]["a"]) != 2 || s2.Nested[0]["a"][1] != 2 || s2.Nested[1]["b"][0] != 3 {
+		t.Errorf("invalid nested field mapping: %v", s2.Nested)
+	}
+}
+
+func TestTimeStruct(t *testing.T) {
+	type st struct {
+		Time     time.Time
+		TimeP    *time.Time
+		NilTimeP *time.Time
+		FromTs   time.Time
+		Times    []time.Time
+	}
+
+	tm := time.Date(2016, 1, 2, 3, 4, 5, 6789000, time.UTC)
+	s := st{Time: tm, TimeP: &tm, Times: []time.Time{tm}}
+	b := bson.StructToBson(&s)
+
+	want := bson.Doc{
+		{"Time", bson.NewDate(tm)},
+		{"TimeP", bson.NewDate(tm)},
+		{"NilTimeP", nil},
+		{"FromTs", bson.NewDate(time.Time{})},
+		{"Times", []interface{}{bson.NewDate(tm)}},
+	}.Bson()
+	if b.String() != want.String() {
+		t.Errorf("expected: %s\n  actual: %s", want, b)
+	}
+
+	b = bson.Doc{
+		{"Time", bson.NewDate(tm)},
+		{"TimeP", bson.NewDate(tm)},
+		{"FromTs", bson.Timestamp{Second: 10, Increment: 20}},
+		{"Times", []interface{}{bson.NewDate(tm)}},
+	}.Bson()
+	var s2 st
+	b.Struct(&s2)
+
+	tm = tm.Truncate(time.Millisecond)
+	if s2.Time != tm || s2.TimeP == nil || *s2.TimeP != tm || s2.NilTimeP != nil ||
+		s2.FromTs != time.Unix(10, 20000).UTC() || len(s2.Times) != 1 || s2.Times[0] != tm {
+		t.Errorf("invalid time field mapping: %+v", s2)
+	}
+
+	var s3 st
+	bson.Doc{{"Time", "2016-01-02T03:04:05Z"}}.Bson().Struct(&s3)
+	if s3.Time != time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC) {
+		t.Errorf("invalid time string mapping: %v", s3.Time)
+	}
+}
+
+func TestNestedDecodeStruct(t *testing.T) {
+	type inner struct {
+		A int
+		B string
+	}
+	type st struct {
+		Map    map[string]int
+		Inners map[string]inner
+		P      *inner
+		Null   *inner
+		PP     **int
+		PS     []*inner
+		N      inner
+		MP     map[string]*inner
+	}
+
+	b := bson.Doc{
+		{"Map", bson.Doc{{"x", 1}, {"y", 2}}},
+		{"Inners", bson.Doc{{"i", bson.Doc{{"A", 1}, {"B", "b"}}}}},
+		{"P", bson.Doc{{"A", 2}}},
+		{"Null", nil},
+		{"PP", 3},
+		{"PS", []interface{}{bson.Doc{{"A", 4}}, nil}},
+		{"N", bson.Doc{{"B", "n"}}},
+		{"MP", bson.Doc{{"p", bson.Doc{{"A", 5}}}, {"nil", nil}}},
+	}.Bson()
+
+	s := st{Null: &inner{A: 1}}
+	b.Struct(&s)
+
+	three := 3
+	pthree := &three
+	expected := st{
+		Map:    map[string]int{"x": 1, "y": 2},
+		Inners: map[string]inner{"i": {1, "b"}},
+		P:      &inner{A: 2},
+		PP:     &pthree,
+		PS:     []*inner{{A: 4}, nil},
+		N:      inner{B: "n"},
+		MP:     map[string]*inner{"p": {A: 5}, "nil": nil},
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected: %+v\n  actual: %+v", expected, s)
+	}
+}
+
+func TestInterfaceDocumentType(t *testing.T) {
+	type st struct {
+		Doc   interface{}
+		Array interface{}
+		Int   interface{}
+		Null  interface{}
+	}
+
+	b := bson.Doc{
+		{"Doc", bson.Doc{{"a", 1}}},
+		{"Array", []interface{}{bson.Doc{{"b", 2}}}},
+		{"Int", 3},
+		{"Null", nil},
+	}.Bson()
+
+	var tests = []struct {
+		opts  *bson.DecodeOptions
+		doc   interface{}
+		array interface{}
+	}{
+		{nil, bson.Doc{{"a", int32(1)}}, []interface{}{bson.Doc{{"b", int32(2)}}}},
+		{&bson.DecodeOptions{DocumentType: bson.DocumentTypeDoc}, bson.Doc{{"a", int32(1)}}, []interface{}{bson.Doc{{"b", int32(2)}}}},
+		{&bson.DecodeOptions{DocumentType: bson.DocumentTypeMap}, bson.Map{"a": int32(1)}, []interface{}{bson.Map{"b": int32(2)}}},
+	}
+
+	for i, test := range tests {
+		s := st{Null: "x"}
+		b.StructWithOptions(&s, test.opts)
+		if !reflect.DeepEqual(s.Doc, test.doc) || !reflect.DeepEqual(s.Array, test.array) || s.Int != int32(3) || s.Null != nil {
+			t.Errorf("case %d: unexpected result: %+v", i, s)
+		}
+	}
+
+	var s st
+	b.StructWithOptions(&s, &bson.DecodeOptions{DocumentType: bson.DocumentTypeBson})
+	if d, ok := s.Doc.(*bson.Bson); !ok || d.String() != `{"a":1}` {
+		t.Errorf("expected *Bson, actual %#v", s.Doc)
+	}
+	if a, ok := s.Array.(*bson.BsonArray); !ok || a.String() != `[{"b":2}]` {
+		t.Errorf("expected *BsonArray, actual %#v", s.Array)
+	}
+}
+
+func TestStructE(t *testing.T) {
+	type inner struct {
+		I8 int8
+		U  uint
+		F  float32
+		S  string
+		T  time.Time
+		P  *int
+	}
+	type st struct {
+		A      int
+		Inner  inner
+		Inners []inner
+		M      map[int]inner
+		Any    interface{}
+	}
+
+	var tests = []struct {
+		doc  bson.Doc
+		opts *bson.DecodeOptions
+		err  string
+	}{
+		{bson.Doc{{"A", "1"}}, nil,
+			`can't decode bson value of type string into field "A" of type int`},
+		{bson.Doc{{"Inner", bson.Doc{{"S", 1}}}}, nil,
+			`can't decode bson value of type int32 into field "Inner.S" of type string`},
+		{bson.Doc{{"Inners", []interface{}{bson.Doc{}, bson.Doc{{"T", "now"}}}}}, nil,
+			`can't decode bson value of type string into field "Inners.1.T" of type time.Time`},
+		{bson.Doc{{"Inner", []interface{}{}}}, nil,
+			`can't decode bson value of type array into field "Inner" of type bson_test.inner`},
+		{bson.Doc{{"M", bson.Doc{{"x", bson.Doc{}}}}}, nil,
+			`can't decode bson value of type document into field "M.x" of type map[int]bson_test.inner: can't parse map key "x" into int`},
+		{bson.Doc{{"B", 1}}, &bson.DecodeOptions{DisallowUnknownFields: true},
+			`unknown field "B" of bson type int32`},
+		{bson.Doc{{"Inner", bson.Doc{{"X", nil}}}}, &bson.DecodeOptions{DisallowUnknownFields: true},
+			`unknown field "Inner.X" of bson type null`},
+		{bson.Doc{{"Inner", bson.Doc{{"I8", 300}}}}, &bson.DecodeOptions{CheckOverflow: true},
+			`can't decode bson value of type int32 into field "Inner.I8" of type int8: value 300 overflows int8`},
+		{bson.Doc{{"Inner", bson.Doc{{"U", -1}}}}, &bson.DecodeOptions{CheckOverflow: true},
+			`can't decode bson value of type int32 into field "Inner.U" of type uint: value -1 overflows uint`},
+		{bson.Doc{{"A", 1.5}}, &bson.DecodeOptions{CheckOverflow: true},
+			`can't decode bson value of type float64 into field "A" of type int: value 1.5 overflows int`},
+		{bson.Doc{{"Inner", bson.Doc{{"F", 1e300}}}}, &bson.DecodeOptions{CheckOverflow: true},
+			`can't decode bson value of type float64 into field "Inner.F" of type float32: value 1e+300 overflows float32`},
+	}
+
+	for i, test := range tests {
+		var s st
+		err := test.doc.Bson().StructE(&s, test.opts)
+		if err == nil || len(err.Error()) < len(test.err) || err.Error()[:len(test.err)] != test.err {
+			t.Errorf("case %d: expected error %q, actual %v", i, test.err, err)
+		}
+		if _, ok := err.(*bson.DecodeError); !ok {
+			t.Errorf("case %d: expected *DecodeError, actual %T", i, err)
+		}
+	}
+
+	// valid values
+	var s st
+	b := bson.Doc{
+		{"A", int64(1)},
+		{"Inner", bson.Doc{{"I8", 127}, {"U", 2}, {"F", 1.5}, {"S", "s"}, {"P", nil}}},
+		{"Inners", []interface{}{bson.Doc{{"I8", 2.0}}}},
+		{"M", bson.Doc{{"1", bson.Doc{{"S", "m"}}}}},
+		{"Any", bson.Doc{{"x", 1}}},
+		{"Null", nil},
+	}.Bson()
+	if err := b.StructE(&s, &bson.DecodeOptions{CheckOverflow: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if s.A != 1 || s.Inner.I8 != 127 || s.Inner.U != 2 || s.Inner.F != 1.5 || s.Inner.S != "s" ||
+		len(s.Inners) != 1 || s.Inners[0].I8 != 2 || s.M[1].S != "m" || s.Any == nil {
+		t.Errorf("unexpected result: %+v", s)
+	}
+
+	if err := b.StructE(s, nil); err == nil {
+		t.Errorf("expected error of non pointer")
+	}
+}
+
+func TestStructOptionsPanic(t *testing.T) {
+	type st struct {
+		A int8
+	}
+
+	// not strict, the mismatched values are skipped
+	var s st
+	bson.Doc{{"A", "x"}, {"B", 1}}.Bson().StructWithOptions(&s, nil)
+	if s.A != 0 {
+		t.Errorf("unexpected result: %+v", s)
+	}
+
+	var tests = []*bson.DecodeOptions{
+		{DisallowUnknownFields: true},
+		{CheckOverflow: true},
+	}
+	for i, opts := range tests {
+		func() {
+			defer func() {
+				if _, ok := recover().(*bson.DecodeError); !ok {
+					t.Errorf("case %d: expected panic of *DecodeError", i)
+				}
+			}()
+			bson.Doc{{"A", 1000}, {"B", 1}}.Bson().StructWithOptions(&s, opts)
+		}()
+	}
+}
+
+type EmbeddedBase struct {
+	ID   int
+	Name string
+}
+
+type EmbeddedTagged struct {
+	Name string `bson:"Name"`
+}
+
+type EmbeddedDeep struct {
+	EmbeddedBase
+	Deep int
+}
+
+type embeddedPrivate struct {
+	Private int
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	type st struct {
+		EmbeddedBase
+		*EmbeddedDeep
+		embeddedPrivate
+		bson.Date
+		Named EmbeddedBase
+		ID    string // shallower than EmbeddedBase.ID
+	}
+
+	s := st{
+		EmbeddedBase:    EmbeddedBase{ID: 1, Name: "base"},
+		EmbeddedDeep:    &EmbeddedDeep{EmbeddedBase: EmbeddedBase{ID: 2, Name: "deep"}, Deep: 3},
+		embeddedPrivate: embeddedPrivate{Private: 4},
+		Date:            5,
+		Named:           EmbeddedBase{ID: 6},
+		ID:              "id",
+	}
+	b := bson.StructToBson(&s)
+	expected := `{"Name":"base", "Deep":3, "Private":4, "Date":{"$date":5}, "Named":{"ID":6, "Name":""}, "ID":"id"}`
+	if b.String() != expected {
+		t.Errorf("expected: %s\n  actual: %s", expected, b)
+	}
+
+	var s2 st
+	b.Struct(&s2)
+	// EmbeddedBase.ID is hidden by ID, and EmbeddedDeep.Name by EmbeddedBase.Name
+	expectedStruct := st{
+		EmbeddedBase:    EmbeddedBase{Name: "base"},
+		EmbeddedDeep:    &EmbeddedDeep{Deep: 3},
+		embeddedPrivate: embeddedPrivate{Private: 4},
+		Date:            5,
+		Named:           EmbeddedBase{ID: 6},
+		ID:              "id",
+	}
+	if !reflect.DeepEqual(expectedStruct, s2) {
+		t.Errorf("expected: %+v\n  actual: %+v", expectedStruct, s2)
+	}
+
+	// nil embedded pointer
+	b = bson.StructToBson(&st{ID: "nil"})
+	expected = `{"Name":"", "Private":0, "Date":{"$date":0}, "Named":{"ID":0, "Name":""}, "ID":"nil"}`
+	if b.String() != expected {
+		t.Errorf("expected: %s\n  actual: %s", expected, b)
+	}
+}
+
+func TestEmbeddedConflict(t *testing.T) {
+	// the tagged one wins at the same depth
+	type tagged struct {
+		EmbeddedBase
+		EmbeddedTagged
+	}
+	b := bson.StructToBson(&tagged{EmbeddedBase{1, "base"}, EmbeddedTagged{"tagged"}})
+	if b.String() != `{"ID":1, "Name":"tagged"}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+
+	// the shallower one wins: ID and Name are at depth 1 of EmbeddedBase and depth 2 of EmbeddedDeep
+	type shallower struct {
+		EmbeddedBase
+		EmbeddedDeep
+	}
+	b = bson.StructToBson(&shallower{EmbeddedBase{1, "a"}, EmbeddedDeep{EmbeddedBase{2, "b"}, 3}})
+	if b.String() != `{"ID":1, "Name":"a", "Deep":3}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+
+	// both are dropped if it is ambiguous
+	type Base2 struct {
+		ID int
+	}
+	type same struct {
+		EmbeddedBase
+		Base2
+	}
+	b = bson.StructToBson(&same{EmbeddedBase{1, "a"}, Base2{2}})
+	if b.String() != `{"Name":"a"}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+
+	var s same
+	bson.Doc{{"ID", 3}, {"Name", "b"}}.Bson().Struct(&s)
+	if s.EmbeddedBase.ID != 0 || s.Base2.ID != 0 || s.Name != "b" {
+		t.Errorf("unexpected result: %+v", s)
+	}
+
+	// the fields of the struct itself can't conflict
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic of duplicated key")
+		}
+	}()
+	type duplicated struct {
+		A int
+		B int `bson:"A"`
+	}
+	bson.StructToBson(&duplicated{})
+}
+
+type EmbeddedCycleA struct {
+	*EmbeddedCycleB
+	X int
+}
+
+type EmbeddedCycleB struct {
+	*EmbeddedCycleA
+	Y int
+}
+
+func TestEmbeddedCycle(t *testing.T) {
+	b := bson.StructToBson(EmbeddedCycleA{X: 1})
+	if b.String() != `{"X":1}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+
+	b = bson.StructToBson(EmbeddedCycleA{&EmbeddedCycleB{Y: 2}, 1})
+	if b.String() != `{"Y":2, "X":1}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+
+	b = bson.StructToBson(EmbeddedCycleB{&EmbeddedCycleA{X: 1}, 2})
+	if b.String() != `{"X":1, "Y":2}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+
+	var a EmbeddedCycleA
+	bson.Doc{{"X", 3}, {"Y", 4}}.Bson().Struct(&a)
+	if a.X != 3 || a.EmbeddedCycleB == nil || a.Y != 4 || a.EmbeddedCycleA != nil {
+		t.Errorf("unexpected result: %+v", a)
+	}
+
+	// through an inline struct
+	type inline struct {
+		A EmbeddedCycleA `bson:",inline"`
+		Z int
+	}
+	b = bson.StructToBson(inline{EmbeddedCycleA{&EmbeddedCycleB{Y: 2}, 1}, 3})
+	if b.String() != `{"Y":2, "X":1, "Z":3}` {
+		t.Errorf("unexpected result: %s", b)
+	}
+}
+
+func TestStructConcurrent(t *testing.T) {
+	type st struct {
+		A int    `bson:"a"`
+		B string `bson:"b,omitempty"`
+		P primary
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := st{A: i, B: "b", P: pridata}
+				var s2 st
+				bson.StructToBson(&s).Struct(&s2)
+				if s != s2 {
+					t.Errorf("invalid concurrent struct mapping")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
 func BenchmarkSdbBsonMap(t *testing.B) {
 	for i := 0; i < t.N; i++ {
 		m := mdata2
@@ -1073,6 +1724,21 @@ func BenchmarkSdbBsonDoc(t *testing.B) {
 	}
 }
 
+func BenchmarkSdbBsonDocPooled(t *testing.B) {
+	for i := 0; i < t.N; i++ {
+		b := bson.AcquireBsonBuilder()
+		b.AppendDoc(ddata).Finish()
+		bson.ReleaseBsonBuilder(b)
+	}
+}
+
+func BenchmarkSdbBsonDocReset(t *testing.B) {
+	b := bson.NewBsonBuilder()
+	for i := 0; i < t.N; i++ {
+		b.Reset().AppendDoc(ddata).Finish()
+	}
+}
+
 func BenchmarkSdbBsonStruct(t *testing.B) {
 	for i := 0; i < t.N; i++ {
 		s := pridata
@@ -1084,6 +1750,15 @@ func BenchmarkSdbBsonStruct(t *testing.B) {
 	}
 }
 
+func BenchmarkSdbBsonStructDecode(t *testing.B) {
+	b := bson.StructToBson(pridata)
+	t.ResetTimer()
+	for i := 0; i < t.N; i++ {
+		var s primary
+		b.Struct(&s)
+	}
+}
+
 func BenchmarkSdbBsonAppendXXX(t *testing.B) {
 	for i := 0; i < t.N; i++ {
 		b := bson.NewBsonBuilder()
@@ -0,0 +1,573 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var (
+	typeBsonPtr      = reflect.TypeOf((*Bson)(nil))
+	typeBsonArrayPtr = reflect.TypeOf((*BsonArray)(nil))
+	typeDoc          = reflect.TypeOf(Doc{})
+	typeObjectId     = reflect.TypeOf(ObjectId(""))
+	typeDate         = reflect.TypeOf(Date(0))
+	typeTime         = reflect.TypeOf(time.Time{})
+)
+
+// DocumentType is the type of documents decoded into interface{} values.
+type DocumentType int
+
+const (
+	// DocumentTypeDoc decodes documents as Doc and arrays as []interface{} of Docs, the default.
+	DocumentTypeDoc DocumentType = iota
+	// DocumentTypeMap decodes documents as Map and arrays as []interface{} of Maps.
+	DocumentTypeMap
+	// DocumentTypeBson decodes documents as *Bson and arrays as *BsonArray without copying.
+	DocumentTypeBson
+)
+
+// DecodeOptions are the options of decoding bson into structs.
+type DecodeOptions struct {
+	// Registry is used to decode the values, DefaultRegistry if nil.
+	Registry *Registry
+
+	// DocumentType is the type of documents decoded into interface{} values.
+	DocumentType DocumentType
+
+	// DisallowUnknownFields makes the fields of documents that match
+	// no struct field, and there is no inline map, errors.
+	DisallowUnknownFields bool
+
+	// CheckOverflow makes the numbers that don't fit the fields errors,
+	// e.g. 300 into int8, -1 into uint or 1.5 into int.
+	CheckOverflow bool
+}
+
+// DecodeError is the error of decoding a bson value into a struct field.
+type DecodeError struct {
+	Path     string       // the dotted path of the field, e.g. "a.b.0"
+	BsonType BsonType     // the type of the bson value
+	Type     reflect.Type // the type of the field, nil if the field is unknown
+	Msg      string       // the reason if any
+}
+
+func (e *DecodeError) Error() string {
+	if e.Type == nil {
+		return fmt.Sprintf("unknown field %q of bson type %v", e.Path, e.BsonType)
+	}
+	msg := fmt.Sprintf("can't decode bson value of type %v into field %q of type %s", e.BsonType, e.Path, e.Type)
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	return msg
+}
+
+// decoder is the state of decoding shared by the nested documents.
+type decoder struct {
+	registry *Registry // nil if there is no registered decoder
+	docType  DocumentType
+
+	disallowUnknownFields bool
+	checkOverflow         bool
+
+	// the values that can't be decoded are errors instead of being skipped,
+	// and the errors are recorded instead of panicking
+	strict bool
+	err    error
+
+	// the names of the current field and its parents for the errors
+	path    [][]byte
+	pathBuf [4][]byte
+}
+
+func newDecoder(opts *DecodeOptions, strict bool) *decoder {
+	d := &decoder{registry: DefaultRegistry.active(), strict: strict}
+	d.path = d.pathBuf[:0]
+	if opts != nil {
+		if opts.Registry != nil {
+			d.registry = opts.Registry.active()
+		}
+		d.docType = opts.DocumentType
+		d.disallowUnknownFields = opts.DisallowUnknownFields
+		d.checkOverflow = opts.CheckOverflow
+	}
+	return d
+}
+
+func (d *decoder) push(it *BsonIterator) {
+	d.path = append(d.path, it.name())
+}
+
+func (d *decoder) pop() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+func (d *decoder) currentPath() string {
+	return string(bytes.Join(d.path, []byte(".")))
+}
+
+// fail panics with err, or records it in the strict mode.
+func (d *decoder) fail(err error) {
+	if !d.strict {
+		panic(err)
+	}
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) failed() bool {
+	return d.err != nil
+}
+
+// mismatch reports that the current value of it can't be decoded into f in the strict mode,
+// otherwise the value is skipped. Null is never an error.
+func (d *decoder) mismatch(f reflect.Value, it *BsonIterator) {
+	if d.strict && it.BsonType() != BsonTypeNull {
+		d.fail(&DecodeError{Path: d.currentPath(), BsonType: it.BsonType(), Type: f.Type()})
+	}
+}
+
+// unmarshalError reports the error of the Unmarshaler of f in the strict mode,
+// otherwise the value is skipped like a mismatched one.
+func (d *decoder) unmarshalError(f reflect.Value, it *BsonIterator, err error) {
+	if !d.strict {
+		return
+	}
+	d.fail(&DecodeError{
+		Path:     d.currentPath(),
+		BsonType: it.BsonType(),
+		Type:     f.Type(),
+		Msg:      fmt.Sprintf("can't unmarshal: %v", err),
+	})
+}
+
+// overflow reports that the number v doesn't fit f.
+func (d *decoder) overflow(f reflect.Value, it *BsonIterator, v interface{}) {
+	d.fail(&DecodeError{
+		Path:     d.currentPath(),
+		BsonType: it.BsonType(),
+		Type:     f.Type(),
+		Msg:      fmt.Sprintf("value %v overflows %s", v, f.Type()),
+	})
+}
+
+// bsonToStruct decodes the raw document into struct s field by field,
+// without converting the document to Doc first.
+func bsonToStruct(s reflect.Value, raw []byte, d *decoder) {
+	info := getStructInfo(s.Type())
+	r := d.registry
+
+	var it BsonIterator
+	it.init(raw)
+	it.decoder = d
+	for !d.failed() && it.Next() {
+		d.push(&it)
+		if f, exist := info.FieldsMap[string(it.name())]; exist {
+			fv := fieldByIndex(s, f.Index, true)
+			if r == nil || !r.decode(fv, &it) {
+				f.decode(fv, &it)
+			}
+		} else if info.InlineMap != nil {
+			m := fieldByIndex(s, info.InlineMap, true)
+			if m.IsNil() {
+				m.Set(reflect.MakeMap(m.Type()))
+			}
+			ev := reflect.New(m.Type().Elem()).Elem()
+			decodeValue(ev, &it)
+			m.SetMapIndex(reflect.ValueOf(it.Name()).Convert(m.Type().Key()), ev)
+		} else if d.disallowUnknownFields {
+			d.fail(&DecodeError{Path: d.currentPath(), BsonType: it.BsonType()})
+		}
+		d.pop()
+	}
+}
+
+// interfaceValue returns the current value of it for interface{} values,
+// in the document type of the decoder.
+func interfaceValue(it *BsonIterator) interface{} {
+	switch it.decoder.docType {
+	case DocumentTypeMap:
+		switch it.BsonType() {
+		case BsonTypeBson:
+			return it.Bson().Map()
+		case BsonTypeArray:
+			return it.BsonArray().MapSlice()
+		}
+	case DocumentTypeBson:
+		switch it.BsonType() {
+		case BsonTypeBson:
+			return &Bson{raw: it.document()}
+		case BsonTypeArray:
+			return &BsonArray{bson: Bson{raw: it.document()}}
+		}
+	}
+	return docValue(it)
+}
+
+// docValue returns the current value of it in the form of Bson.Doc().
+func docValue(it *BsonIterator) interface{} {
+	switch it.BsonType() {
+	case BsonTypeBson:
+		return it.Bson().Doc()
+	case BsonTypeArray:
+		return it.BsonArray().DocSlice()
+	default:
+		return it.Value()
+	}
+}
+
+// decodeValue sets f to the current value of it, by the Unmarshaler of f if any.
+// Values that can't be decoded directly are passed to setFieldValue,
+// or are errors in the strict mode.
+func decodeValue(f reflect.Value, it *BsonIterator) {
+	d := it.decoder
+	if r := d.registry; r != nil && r.decode(f, it) {
+		return
+	}
+	if unmarshalValue(f, it) {
+		return
+	}
+
+	t := it.BsonType()
+
+	switch f.Type() {
+	case typeBsonPtr:
+		if t == BsonTypeBson {
+			f.Set(reflect.ValueOf(it.Bson()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeBsonArrayPtr:
+		if t == BsonTypeArray {
+			f.Set(reflect.ValueOf(it.BsonArray()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeDoc:
+		if t == BsonTypeBson {
+			f.Set(reflect.ValueOf(it.Bson().Doc()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeObjectId:
+		if t == BsonTypeObjectId {
+			f.SetString(string(it.ObjectId()))
+			return
+		}
+	case typeDate:
+		if t == BsonTypeDate {
+			f.SetInt(it.Int64())
+			return
+		}
+	case typeTime:
+		switch t {
+		case BsonTypeDate:
+			f.Set(reflect.ValueOf(it.Date().Time()))
+		case BsonTypeTimestamp:
+			f.Set(reflect.ValueOf(it.Timestamp().Time()))
+		default:
+			d.mismatch(f, it)
+		}
+		return
+	case typeBinary:
+		if t == BsonTypeBinary {
+			f.Set(reflect.ValueOf(it.Binary()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeRegEx:
+		if t == BsonTypeRegEx {
+			f.Set(reflect.ValueOf(it.RegEx()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeTimestamp:
+		if t == BsonTypeTimestamp {
+			f.Set(reflect.ValueOf(it.Timestamp()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeDecimal:
+		if t == BsonTypeDecimal {
+			f.Set(reflect.ValueOf(it.Decimal()))
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case typeRawValue:
+		f.Set(reflect.ValueOf(it.RawValue()))
+		return
+	}
+
+	switch f.Kind() {
+	case reflect.Interface:
+		if t == BsonTypeNull {
+			f.Set(reflect.Zero(f.Type()))
+			return
+		}
+		v := reflect.ValueOf(interfaceValue(it))
+		if v.Type().AssignableTo(f.Type()) {
+			f.Set(v)
+		} else {
+			d.mismatch(f, it)
+		}
+		return
+	case reflect.Ptr:
+		if t == BsonTypeNull {
+			f.Set(reflect.Zero(f.Type()))
+			return
+		}
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		decodeValue(f.Elem(), it)
+		return
+	case reflect.Struct:
+		if t == BsonTypeBson {
+			bsonToStruct(f, it.document(), d)
+			return
+		}
+	case reflect.Map:
+		if t == BsonTypeBson {
+			decodeMap(f, it.document(), d)
+			return
+		}
+	case reflect.Slice:
+		if t == BsonTypeArray {
+			decodeSlice(f, it.document(), d)
+			return
+		}
+	case reflect.Array:
+		if t == BsonTypeArray {
+			decodeArray(f, it.document(), d)
+			return
+		}
+	case reflect.Bool:
+		decodeBool(f, it)
+		return
+	case reflect.String:
+		decodeString(f, it)
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		decodeInt(f, it)
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		decodeUint(f, it)
+		return
+	case reflect.Float32, reflect.Float64:
+		decodeFloat(f, it)
+		return
+	}
+
+	if d.strict {
+		d.mismatch(f, it)
+		return
+	}
+	setFieldValue(f, docValue(it))
+}
+
+func decodeMap(f reflect.Value, raw []byte, d *decoder) {
+	ft := f.Type()
+	if f.IsNil() {
+		f.Set(reflect.MakeMap(ft))
+	}
+
+	var it BsonIterator
+	it.init(raw)
+	it.decoder = d
+	for !d.failed() && it.Next() {
+		d.push(&it)
+		k, err := parseMapKey(it.Name(), ft.Key())
+		if err != nil {
+			d.fail(&DecodeError{Path: d.currentPath(), BsonType: it.BsonType(), Type: ft, Msg: err.Error()})
+		} else {
+			ev := reflect.New(ft.Elem()).Elem()
+			decodeValue(ev, &it)
+			f.SetMapIndex(k, ev)
+		}
+		d.pop()
+	}
+}
+
+func decodeSlice(f reflect.Value, raw []byte, d *decoder) {
+	var it BsonIterator
+	it.init(raw)
+	it.decoder = d
+
+	n := 0
+	for it.Next() {
+		n++
+	}
+
+	s := reflect.MakeSlice(f.Type(), n, n)
+	it.Reset()
+	for i := 0; !d.failed() && it.Next(); i++ {
+		d.push(&it)
+		decodeValue(s.Index(i), &it)
+		d.pop()
+	}
+	f.Set(s)
+}
+
+func decodeArray(f reflect.Value, raw []byte, d *decoder) {
+	var it BsonIterator
+	it.init(raw)
+	it.decoder = d
+
+	n := f.Len()
+	for i := 0; i < n && !d.failed() && it.Next(); i++ {
+		d.push(&it)
+		decodeValue(f.Index(i), &it)
+		d.pop()
+	}
+}
+
+func decodeBool(f reflect.Value, it *BsonIterator) {
+	switch it.BsonType() {
+	case BsonTypeBool:
+		f.SetBool(it.Bool())
+	case BsonTypeInt32:
+		f.SetBool(it.Int32() != 0)
+	case BsonTypeInt64, BsonTypeDate:
+		f.SetBool(it.Int64() != 0)
+	case BsonTypeFloat64:
+		f.SetBool(it.Float64() != 0)
+	default:
+		decodeOther(f, it)
+	}
+}
+
+func decodeString(f reflect.Value, it *BsonIterator) {
+	switch it.BsonType() {
+	case BsonTypeString:
+		f.SetString(it.UTF8String())
+	case BsonTypeObjectId:
+		f.SetString(string(it.ObjectId()))
+	default:
+		decodeOther(f, it)
+	}
+}
+
+func decodeInt(f reflect.Value, it *BsonIterator) {
+	switch it.BsonType() {
+	case BsonTypeInt32:
+		setInt(f, it, int64(it.Int32()))
+	case BsonTypeInt64, BsonTypeDate:
+		setInt(f, it, it.Int64())
+	case BsonTypeFloat64:
+		v := it.Float64()
+		if it.decoder.checkOverflow && (v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64) {
+			it.decoder.overflow(f, it, v)
+			return
+		}
+		setInt(f, it, int64(v))
+	case BsonTypeBool:
+		if it.Bool() {
+			f.SetInt(1)
+		} else {
+			f.SetInt(0)
+		}
+	default:
+		decodeOther(f, it)
+	}
+}
+
+func setInt(f reflect.Value, it *BsonIterator, v int64) {
+	if it.decoder.checkOverflow && f.OverflowInt(v) {
+		it.decoder.overflow(f, it, v)
+		return
+	}
+	f.SetInt(v)
+}
+
+func decodeUint(f reflect.Value, it *BsonIterator) {
+	switch it.BsonType() {
+	case BsonTypeInt32:
+		setUint(f, it, int64(it.Int32()))
+	case BsonTypeInt64, BsonTypeDate:
+		setUint(f, it, it.Int64())
+	case BsonTypeFloat64:
+		v := it.Float64()
+		if it.decoder.checkOverflow && (v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 || f.OverflowUint(uint64(v))) {
+			it.decoder.overflow(f, it, v)
+			return
+		}
+		f.SetUint(uint64(v))
+	case BsonTypeBool:
+		if it.Bool() {
+			f.SetUint(1)
+		} else {
+			f.SetUint(0)
+		}
+	default:
+		decodeOther(f, it)
+	}
+}
+
+func setUint(f reflect.Value, it *BsonIterator, v int64) {
+	if it.decoder.checkOverflow && (v < 0 || f.OverflowUint(uint64(v))) {
+		it.decoder.overflow(f, it, v)
+		return
+	}
+	f.SetUint(uint64(v))
+}
+
+func decodeFloat(f reflect.Value, it *BsonIterator) {
+	switch it.BsonType() {
+	case BsonTypeFloat64:
+		v := it.Float64()
+		if it.decoder.checkOverflow && f.OverflowFloat(v) {
+			it.decoder.overflow(f, it, v)
+			return
+		}
+		f.SetFloat(v)
+	case BsonTypeInt32:
+		f.SetFloat(float64(it.Int32()))
+	case BsonTypeInt64, BsonTypeDate:
+		f.SetFloat(float64(it.Int64()))
+	case BsonTypeBool:
+		if it.Bool() {
+			f.SetFloat(1)
+		} else {
+			f.SetFloat(0)
+		}
+	default:
+		decodeOther(f, it)
+	}
+}
+
+// decodeOther handles the values that the fast decoders don't know.
+func decodeOther(f reflect.Value, it *BsonIterator) {
+	if it.BsonType() == BsonTypeNull {
+		return
+	}
+	if it.decoder.strict {
+		it.decoder.mismatch(f, it)
+		return
+	}
+	setFieldValue(f, docValue(it))
+}
@@ -0,0 +1,466 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type structInfo struct {
+	Fields    []fieldInfo
+	FieldsMap map[string]fieldInfo
+	InlineMap []int // index of the inline map field, nil if none
+
+	size int32 // the largest encoded size, the buffer size of the next encoding, accessed atomically
+}
+
+// sizeHint returns the buffer size to encode a struct, which is the largest size encoded before.
+func (info *structInfo) sizeHint() int {
+	return int(atomic.LoadInt32(&info.size))
+}
+
+// updateSize records the encoded size n, the sizes of the huge ones are not kept.
+func (info *structInfo) updateSize(n int) {
+	if n > maxPooledBufferSize {
+		return
+	}
+	for {
+		size := atomic.LoadInt32(&info.size)
+		if int32(n) <= size || atomic.CompareAndSwapInt32(&info.size, size, int32(n)) {
+			return
+		}
+	}
+}
+
+type fieldInfo struct {
+	Name      string
+	Index     []int // index sequence for reflect.Value.FieldByIndex
+	OmitEmpty bool
+	MinSize   bool
+
+	depth  int  // the depth of embedded structs, 0 for the fields of the struct itself
+	tagged bool // the name is given by the tag
+
+	encode fieldEncoder
+	decode fieldDecoder
+}
+
+// fieldEncoder appends the struct field v to b.
+type fieldEncoder func(b *BsonBuilder, name string, v reflect.Value)
+
+// fieldDecoder sets the struct field f to the current value of it.
+type fieldDecoder func(f reflect.Value, it *BsonIterator)
+
+var structInfoCache = struct {
+	sync.RWMutex
+	m map[reflect.Type]*structInfo
+}{m: map[reflect.Type]*structInfo{}}
+
+// getStructInfo returns the cached structInfo of struct type t.
+func getStructInfo(t reflect.Type) *structInfo {
+	structInfoCache.RLock()
+	info, found := structInfoCache.m[t]
+	structInfoCache.RUnlock()
+	if found {
+		return info
+	}
+
+	info = compileStructInfo(t, map[reflect.Type]bool{})
+
+	structInfoCache.Lock()
+	structInfoCache.m[t] = info
+	structInfoCache.Unlock()
+	return info
+}
+
+// compileStructInfo parses the bson tags of struct type t.
+// A tag has the form `bson:"[name][,omitempty][,minsize][,inline]"`, or `bson:"-"`
+// to skip the field. The Go field name is used if name is empty.
+//
+//	omitempty  skip the field if it has the zero value or is an empty slice, map or string
+//	minsize    accepted for compatibility and has no effect: the integer fields of
+//	           any size, with or without minsize, are stored as int32 if the value
+//	           fits and as int64 otherwise, like BsonBuilder.Append does
+//	inline     flatten the fields of a struct, or the entries of a map[string]T,
+//	           into the parent document; unknown keys are decoded into the inline map
+//
+// The fields of embedded structs without a tag name are promoted like encoding/json:
+// of the fields of the same name, the shallowest one wins, then the tagged one,
+// and if it is still ambiguous, none of them is encoded or decoded.
+// The types in compiling, i.e. the structs embedding t, are not promoted again,
+// so that the structs embedding the pointers of each other terminate.
+func compileStructInfo(t reflect.Type, compiling map[reflect.Type]bool) *structInfo {
+	compiling[t] = true
+	defer delete(compiling, t)
+
+	info := &structInfo{FieldsMap: map[string]fieldInfo{}}
+	var fields []fieldInfo // in the order of declaration
+	n := t.NumField()
+	for i := 0; i < n; i++ {
+		field := t.Field(i)
+		// private field
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("bson")
+		if tag == "-" {
+			continue
+		}
+
+		f := fieldInfo{Index: []int{i}}
+		inline := false
+		flags := strings.Split(tag, ",")
+		for _, flag := range flags[1:] {
+			switch flag {
+			case "omitempty":
+				f.OmitEmpty = true
+			case "minsize":
+				f.MinSize = true
+			case "inline":
+				inline = true
+			default:
+				panic(fmt.Sprintf("unsupported flag %q in tag %q of type %s", flag, tag, t))
+			}
+		}
+
+		if inline {
+			switch field.Type.Kind() {
+			case reflect.Map:
+				if info.InlineMap != nil {
+					panic(fmt.Sprintf("multiple inline maps in struct %s", t))
+				}
+				if field.Type.Key().Kind() != reflect.String {
+					panic(fmt.Sprintf("the key of inline map must be string in struct %s", t))
+				}
+				info.InlineMap = f.Index
+			case reflect.Struct:
+				if compiling[field.Type] {
+					continue
+				}
+				inlineInfo := compileStructInfo(field.Type, compiling)
+				for _, finfo := range inlineInfo.Fields {
+					finfo.Index = append([]int{i}, finfo.Index...)
+					fields = append(fields, finfo)
+				}
+				info.inlineMapOf(t, i, inlineInfo)
+			default:
+				panic(fmt.Sprintf("option ,inline needs a struct value or map field in struct %s", t))
+			}
+			continue
+		}
+
+		if field.Anonymous && flags[0] == "" {
+			if et, ok := embeddedStruct(field); ok {
+				if !compiling[et] {
+					embeddedInfo := compileStructInfo(et, compiling)
+					for _, finfo := range embeddedInfo.Fields {
+						finfo.Index = append([]int{i}, finfo.Index...)
+						finfo.depth++
+						fields = append(fields, finfo)
+					}
+					info.inlineMapOf(t, i, embeddedInfo)
+				}
+				continue
+			}
+			if field.PkgPath != "" {
+				// private embedded non-struct type
+				continue
+			}
+		}
+
+		f.Name = flags[0]
+		f.tagged = f.Name != ""
+		if f.Name == "" {
+			f.Name = field.Name
+		}
+		f.encode = fieldEncoderOf(field.Type)
+		f.decode = fieldDecoderOf(field.Type)
+		fields = append(fields, f)
+	}
+
+	info.addFields(t, fields)
+	return info
+}
+
+// embeddedStruct returns the struct type of the embedded field if its fields are promoted:
+// it is a struct or a pointer to an exported struct, and doesn't implement the marshalers.
+func embeddedStruct(field reflect.StructField) (reflect.Type, bool) {
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		if field.PkgPath != "" {
+			// can't allocate the private pointer
+			return nil, false
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	if implements(t, typeMarshaler) || implements(t, typeTextMarshaler) {
+		return nil, false
+	}
+	return t, true
+}
+
+// inlineMapOf promotes the inline map of the inline or embedded struct field i.
+func (info *structInfo) inlineMapOf(t reflect.Type, i int, fieldInfo *structInfo) {
+	if fieldInfo.InlineMap == nil {
+		return
+	}
+	if info.InlineMap != nil {
+		panic(fmt.Sprintf("multiple inline maps in struct %s", t))
+	}
+	info.InlineMap = append([]int{i}, fieldInfo.InlineMap...)
+}
+
+// addFields adds the fields that win the conflicts of names.
+// The conflicts of the fields of the struct itself are errors.
+func (info *structInfo) addFields(t reflect.Type, fields []fieldInfo) {
+	byName := map[string][]int{}
+	for i, f := range fields {
+		byName[f.Name] = append(byName[f.Name], i)
+	}
+
+	for i, f := range fields {
+		if dominant(t, fields, byName[f.Name]) == i {
+			info.Fields = append(info.Fields, f)
+			info.FieldsMap[f.Name] = f
+		}
+	}
+}
+
+// dominant returns the index of the field that wins in the fields of the same name, or -1 if none.
+func dominant(t reflect.Type, fields []fieldInfo, same []int) int {
+	if len(same) == 1 {
+		return same[0]
+	}
+
+	depth := fields[same[0]].depth
+	for _, i := range same[1:] {
+		if fields[i].depth < depth {
+			depth = fields[i].depth
+		}
+	}
+
+	first, tagged := -1, -1
+	count, taggedCount := 0, 0
+	for _, i := range same {
+		if fields[i].depth != depth {
+			continue
+		}
+		if count == 0 {
+			first = i
+		}
+		count++
+		if fields[i].tagged {
+			tagged = i
+			taggedCount++
+		}
+	}
+
+	switch {
+	case count > 1 && depth == 0:
+		panic(fmt.Sprintf("duplicated key %q in struct %s", fields[first].Name, t))
+	case count == 1:
+		return first
+	case taggedCount == 1:
+		return tagged
+	}
+	return -1
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but the nil embedded struct pointers on the way
+// are allocated if alloc is true, otherwise the invalid Value is returned.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	if len(index) == 1 {
+		return v.Field(index[0])
+	}
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	}
+	return v.IsZero()
+}
+
+var (
+	typeBool    = reflect.TypeOf(false)
+	typeString  = reflect.TypeOf("")
+	typeInt     = reflect.TypeOf(int(0))
+	typeInt8    = reflect.TypeOf(int8(0))
+	typeInt16   = reflect.TypeOf(int16(0))
+	typeInt32   = reflect.TypeOf(int32(0))
+	typeInt64   = reflect.TypeOf(int64(0))
+	typeUint    = reflect.TypeOf(uint(0))
+	typeUint8   = reflect.TypeOf(uint8(0))
+	typeUint16  = reflect.TypeOf(uint16(0))
+	typeUint32  = reflect.TypeOf(uint32(0))
+	typeUint64  = reflect.TypeOf(uint64(0))
+	typeUintptr = reflect.TypeOf(uintptr(0))
+	typeFloat32 = reflect.TypeOf(float32(0))
+	typeFloat64 = reflect.TypeOf(float64(0))
+
+	typeBinary    = reflect.TypeOf(Binary{})
+	typeRegEx     = reflect.TypeOf(RegEx{})
+	typeTimestamp = reflect.TypeOf(Timestamp{})
+	typeDecimal   = reflect.TypeOf(Decimal{})
+	typeRawValue  = reflect.TypeOf(RawValue{})
+)
+
+// fieldEncoderOf returns the encoder of struct fields of type t.
+// The builtin types are encoded without boxing them into interface{},
+// the others, including Marshaler implementations, are passed to BsonBuilder.Append.
+func fieldEncoderOf(t reflect.Type) fieldEncoder {
+	if t.Implements(typeMarshaler) || t.Implements(typeTextMarshaler) {
+		return encodeInterface
+	}
+	if implements(t, typeMarshaler) || implements(t, typeTextMarshaler) {
+		return encodeMarshaler
+	}
+
+	switch t {
+	case typeBool:
+		return encodeBool
+	case typeString:
+		return encodeString
+	case typeInt8, typeInt16, typeInt32:
+		return encodeInt32
+	case typeUint8, typeUint16:
+		return encodeUint32
+	case typeInt, typeInt64:
+		return encodeIntMinSize
+	case typeUint, typeUint32, typeUint64, typeUintptr:
+		return encodeUintMinSize
+	case typeFloat32, typeFloat64:
+		return encodeFloat64
+	case typeBinary, typeRegEx, typeTimestamp, typeDecimal, typeRawValue:
+		return encodeInterface
+	}
+
+	if t.Kind() == reflect.Struct {
+		return encodeStruct
+	}
+	return encodeInterface
+}
+
+func encodeBool(b *BsonBuilder, name string, v reflect.Value) {
+	b.AppendBool(name, v.Bool())
+}
+
+func encodeString(b *BsonBuilder, name string, v reflect.Value) {
+	b.AppendString(name, v.String())
+}
+
+func encodeInt32(b *BsonBuilder, name string, v reflect.Value) {
+	b.AppendInt32(name, int32(v.Int()))
+}
+
+func encodeUint32(b *BsonBuilder, name string, v reflect.Value) {
+	b.AppendInt32(name, int32(v.Uint()))
+}
+
+func encodeIntMinSize(b *BsonBuilder, name string, v reflect.Value) {
+	val := v.Int()
+	if val >= math.MinInt32 && val <= math.MaxInt32 {
+		b.AppendInt32(name, int32(val))
+	} else {
+		b.AppendInt64(name, val)
+	}
+}
+
+func encodeUintMinSize(b *BsonBuilder, name string, v reflect.Value) {
+	val := v.Uint()
+	if val > math.MaxInt64 {
+		b.fail("bson has no uint64 type, and value is too large to fit correctly in an int64")
+		return
+	}
+	if val <= math.MaxInt32 {
+		b.AppendInt32(name, int32(val))
+	} else {
+		b.AppendInt64(name, int64(val))
+	}
+}
+
+func encodeFloat64(b *BsonBuilder, name string, v reflect.Value) {
+	b.AppendFloat64(name, v.Float())
+}
+
+func encodeStruct(b *BsonBuilder, name string, v reflect.Value) {
+	child := b.AppendBsonStart(name)
+	structToBsonBuilder(v, child)
+	child.Finish()
+	child.AppendBsonEnd()
+}
+
+func encodeInterface(b *BsonBuilder, name string, v reflect.Value) {
+	b.Append(name, v.Interface())
+}
+
+// fieldDecoderOf returns the decoder of struct fields of type t.
+func fieldDecoderOf(t reflect.Type) fieldDecoder {
+	if implements(t, typeUnmarshaler) || implements(t, typeTextUnmarshaler) {
+		return decodeValue
+	}
+
+	switch t {
+	case typeBsonPtr, typeBsonArrayPtr, typeDoc, typeRawValue:
+		return decodeValue
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return decodeBool
+	case reflect.String:
+		return decodeString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decodeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return decodeUint
+	case reflect.Float32, reflect.Float64:
+		return decodeFloat
+	}
+	return decodeValue
+}
@@ -0,0 +1,396 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ApplyUpdate applies the SequoiaDB update rule to doc and returns the updated document,
+// doc is not changed. The operators are applied in the order of rule, they are
+//
+//	{"$set": {"a.b": 1}}               sets the values, the missing parent documents are created
+//	{"$unset": {"a": ""}}              removes the fields, the elements of arrays are set to null
+//	{"$inc": {"a": 1}}                 adds the numbers, the missing fields are set
+//	{"$push": {"a": 1}}                appends the value to the array
+//	{"$push_all": {"a": [1, 2]}}       appends the values to the array
+//	{"$addtoset": {"a": [1, 2]}}       appends the values which are not in the array yet
+//	{"$pull": {"a": 1}}                removes the elements equal to the value from the array
+//	{"$pull_all": {"a": [1, 2]}}       removes the elements equal to any of the values from the array
+//	{"$pop": {"a": 1}}                 removes n elements from the end, or -n ones from the start if n is negative
+//	{"$rename": {"a.b": "c"}}          renames the field in its document, i.e. a.b to a.c
+//	{"$replace": {"a": 1}}             replaces the whole document, but keeps its _id
+//
+// The array operators create the missing arrays, and do nothing if there is nothing to remove.
+// The values are compared like CompareValues, e.g. {"$pull": {"a": 1}} removes 1.0 too.
+func ApplyUpdate(doc *Bson, rule Doc) (*Bson, error) {
+	r, err := rule.BsonE()
+	if err != nil {
+		return nil, err
+	}
+	return applyUpdate(doc, r)
+}
+
+func applyUpdate(doc *Bson, rule *Bson) (*Bson, error) {
+	doc = &Bson{raw: append([]byte{}, doc.raw...)}
+	it := rule.Iterator()
+	for it.Next() {
+		op := it.Name()
+		fields, ok := it.RawValue().DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("invalid update rule %s: %v is not a document", op, it.RawValue())
+		}
+
+		var err error
+		if op == "$replace" {
+			doc, err = replaceDocument(doc, fields)
+		} else {
+			fit := fields.Iterator()
+			for fit.Next() && err == nil {
+				doc, err = applyUpdateField(doc, op, fit.Name(), fit.RawValue())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// applyUpdateField applies the operator op with the argument arg to the field path of doc.
+func applyUpdateField(doc *Bson, op string, path string, arg RawValue) (updated *Bson, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("can't update %q by %s: %v", path, op, err)
+		}
+	}()
+
+	switch op {
+	case "$set":
+		return doc.Set(path, arg)
+	case "$unset":
+		return doc.Unset(path)
+	case "$inc":
+		return incField(doc, path, arg)
+	case "$rename":
+		name, ok := arg.StringValueOK()
+		if !ok || name == "" || strings.Contains(name, ".") {
+			return nil, fmt.Errorf("invalid new name %v", arg)
+		}
+		newPath := name
+		if i := strings.LastIndexByte(path, '.'); i >= 0 {
+			newPath = path[:i+1] + name
+		}
+		if newPath == path {
+			return doc, nil
+		}
+		return doc.Rename(path, newPath)
+	case "$push", "$push_all", "$addtoset", "$pull", "$pull_all", "$pop":
+		return updateArray(doc, op, path, arg)
+	default:
+		return nil, errors.New("unknown update operator")
+	}
+}
+
+// incField adds the number arg to the number of path, which is set to arg if it doesn't exist.
+func incField(doc *Bson, path string, arg RawValue) (*Bson, error) {
+	if typeOrder(arg.Type) != typeOrder(BsonTypeInt32) {
+		return nil, fmt.Errorf("%v is not a number", arg)
+	}
+	v, err := doc.Lookup(path)
+	if errors.Is(err, ErrElementNotFound) {
+		return doc.Set(path, arg)
+	} else if err != nil {
+		return nil, err
+	}
+	if typeOrder(v.Type) != typeOrder(BsonTypeInt32) {
+		return nil, fmt.Errorf("bson value of type %v is not a number", v.Type)
+	}
+
+	sum, err := addNumbers(v, arg)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Set(path, sum)
+}
+
+// addNumbers returns the sum of a and b. The sum of int32s is int64 if it overflows,
+// and the sum of any float64 is float64, of any decimal is Decimal.
+func addNumbers(a, b RawValue) (interface{}, error) {
+	switch {
+	case a.Type == BsonTypeDecimal || b.Type == BsonTypeDecimal:
+		return addDecimals(a, b)
+	case a.Type == BsonTypeFloat64 || b.Type == BsonTypeFloat64:
+		return numberFloat64(a) + numberFloat64(b), nil
+	}
+
+	x, y := integerValue(a), integerValue(b)
+	sum := x + y
+	if (sum > x) != (y > 0) {
+		return nil, fmt.Errorf("integer overflow: %d + %d", x, y)
+	}
+	if a.Type == BsonTypeInt32 && b.Type == BsonTypeInt32 && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+		return int32(sum), nil
+	}
+	return sum, nil
+}
+
+func numberFloat64(v RawValue) float64 {
+	if v.Type == BsonTypeFloat64 {
+		return v.Float64()
+	}
+	return float64(integerValue(v))
+}
+
+// addDecimals adds the numbers exactly, the scale of the sum is the largest one of a and b.
+// The precision of the decimal a, which is the current value, is kept.
+func addDecimals(a, b RawValue) (interface{}, error) {
+	x, xs, err := decimalRat(a)
+	if err != nil {
+		return nil, err
+	}
+	y, ys, err := decimalRat(b)
+	if err != nil {
+		return nil, err
+	}
+	if ys > xs {
+		xs = ys
+	}
+
+	d := Decimal{Value: x.Add(x, y).FloatString(xs)}
+	if a.Type == BsonTypeDecimal {
+		old := a.Decimal()
+		d.Precision, d.Scale = old.Precision, old.Scale
+	}
+	return d, nil
+}
+
+// decimalRat returns the exact value of the finite number v and the number of its decimal places.
+func decimalRat(v RawValue) (*big.Rat, int, error) {
+	var s string
+	switch v.Type {
+	case BsonTypeDecimal:
+		s = v.Decimal().Value
+	case BsonTypeFloat64:
+		f := v.Float64()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, 0, fmt.Errorf("can't add %v to decimal", f)
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	default:
+		s = strconv.FormatInt(integerValue(v), 10)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, 0, fmt.Errorf("can't add decimal %s", s)
+	}
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		scale = len(s) - i - 1
+	}
+	return r, scale, nil
+}
+
+// updateArray applies the array operator op to the array of path.
+func updateArray(doc *Bson, op string, path string, arg RawValue) (*Bson, error) {
+	v, err := doc.Lookup(path)
+	exist := err == nil
+	if err != nil && !errors.Is(err, ErrElementNotFound) {
+		return nil, err
+	}
+	if exist && v.Type != BsonTypeArray {
+		return nil, fmt.Errorf("bson value of type %v is not an array", v.Type)
+	}
+
+	var elems []RawValue
+	if exist {
+		it := v.Array().Iterator()
+		for it.Next() {
+			elems = append(elems, it.RawValue())
+		}
+	}
+
+	var args []RawValue
+	switch op {
+	case "$push_all", "$addtoset", "$pull_all":
+		a, ok := arg.ArrayOK()
+		if !ok {
+			return nil, fmt.Errorf("%v is not an array", arg)
+		}
+		it := a.Iterator()
+		for it.Next() {
+			args = append(args, it.RawValue())
+		}
+	case "$pop":
+		if _, ok := conditionInt(arg); !ok {
+			return nil, fmt.Errorf("%v is not an integer", arg)
+		}
+	}
+
+	switch op {
+	case "$push":
+		elems = append(elems, arg)
+	case "$push_all":
+		elems = append(elems, args...)
+	case "$addtoset":
+		for _, a := range args {
+			if indexOfValue(elems, a) < 0 {
+				elems = append(elems, a)
+			}
+		}
+	case "$pull", "$pull_all":
+		if !exist {
+			return doc, nil
+		}
+		if op == "$pull" {
+			args = []RawValue{arg}
+		}
+		kept := elems[:0]
+		for _, e := range elems {
+			if indexOfValue(args, e) < 0 {
+				kept = append(kept, e)
+			}
+		}
+		elems = kept
+	case "$pop":
+		if !exist {
+			return doc, nil
+		}
+		n, _ := conditionInt(arg)
+		switch {
+		case n >= int64(len(elems)) || -n >= int64(len(elems)):
+			elems = nil
+		case n > 0:
+			elems = elems[:int64(len(elems))-n]
+		case n < 0:
+			elems = elems[-n:]
+		}
+	}
+
+	a := NewBsonArrayBuilder()
+	for _, e := range elems {
+		a.AppendRaw(e)
+	}
+	a.Finish()
+	return doc.Set(path, RawValue{Type: BsonTypeArray, Value: a.Raw()})
+}
+
+// indexOfValue returns the index of the first value equal to v, or -1.
+func indexOfValue(values []RawValue, v RawValue) int {
+	for i, value := range values {
+		if value.Compare(v) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceDocument returns the document with doc replaced by the fields, except the _id of doc.
+func replaceDocument(doc *Bson, fields *Bson) (*Bson, error) {
+	replaced := &Bson{raw: append([]byte{}, fields.raw...)}
+	id, err := doc.LookupPath("_id")
+	if err != nil {
+		return replaced, nil
+	}
+	if _, err := fields.LookupPath("_id"); err == nil {
+		return nil, errors.New("can't replace _id")
+	}
+
+	b := NewBsonBuilder()
+	b.AppendRaw("_id", id)
+	it := fields.Iterator()
+	for it.Next() {
+		b.AppendRaw(it.Name(), it.RawValue())
+	}
+	b.Finish()
+	return b.Bson(), nil
+}
+
+// UpsertDocument returns the document inserted by an upsert when no document matches cond:
+// the equality conditions of cond, e.g. {"a": 1, "b.c": {"$et": 2}} and the ones in $and,
+// are set to an empty document, then rule is applied, and the fields of setOnInsert are set.
+func UpsertDocument(cond Doc, rule Doc, setOnInsert Doc) (*Bson, error) {
+	c, err := cond.BsonE()
+	if err != nil {
+		return nil, err
+	}
+	r, err := rule.BsonE()
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := setEqualities(&Bson{raw: emptyDocument}, c)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err = applyUpdate(doc, r); err != nil {
+		return nil, err
+	}
+	for _, e := range setOnInsert {
+		if doc, err = doc.Set(e.Name, e.Value); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// setEqualities sets the fields of the equality conditions of cond to doc.
+func setEqualities(doc *Bson, cond *Bson) (*Bson, error) {
+	var err error
+	it := cond.Iterator()
+	for it.Next() {
+		name, v := it.Name(), it.RawValue()
+		switch {
+		case name == "$and":
+			a, ok := v.ArrayOK()
+			if !ok {
+				return nil, fmt.Errorf("invalid condition $and: %v is not an array", v)
+			}
+			ait := a.Iterator()
+			for ait.Next() {
+				c, ok := ait.RawValue().DocumentOK()
+				if !ok {
+					return nil, fmt.Errorf("invalid condition $and: %v is not a document", ait.RawValue())
+				}
+				if doc, err = setEqualities(doc, c); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case strings.HasPrefix(name, "$"):
+			// $or and $not are not equalities
+			continue
+		case isOperatorDocument(v):
+			et, err := v.Document().LookupPath("$et")
+			if err != nil {
+				continue
+			}
+			v = et
+		case v.Type == BsonTypeRegEx:
+			continue
+		}
+
+		if doc, err = doc.Set(name, v); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
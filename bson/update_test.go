@@ -0,0 +1,139 @@
+// Copyright 2015-2016 David Li
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bson_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+func TestApplyUpdate(t *testing.T) {
+	doc := bson.Doc{
+		{"_id", 7},
+		{"a", 1},
+		{"b", bson.Doc{{"c", "x"}}},
+		{"e", []interface{}{1, 2, 3, 2}},
+	}.Bson()
+	orig := doc.String()
+
+	var tests = []struct {
+		rule     bson.Doc
+		expected string
+	}{
+		{bson.Doc{}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 2, 3, 2]}`},
+		{bson.Doc{{"$set", bson.Doc{{"a", "s"}, {"b.d", 2}, {"f.g", true}}}},
+			`{"_id":7, "a":"s", "b":{"c":"x", "d":2}, "e":[1, 2, 3, 2], "f":{"g":true}}`},
+		{bson.Doc{{"$set", bson.Doc{{"e.1", 5}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 5, 3, 2]}`},
+		{bson.Doc{{"$unset", bson.Doc{{"a", ""}, {"b.c", ""}, {"x", ""}}}}, `{"_id":7, "b":{}, "e":[1, 2, 3, 2]}`},
+		{bson.Doc{{"$inc", bson.Doc{{"a", 2}, {"n", int64(3)}}}}, `{"_id":7, "a":3, "b":{"c":"x"}, "e":[1, 2, 3, 2], "n":3}`},
+		{bson.Doc{{"$inc", bson.Doc{{"a", 0.5}}}}, `{"_id":7, "a":1.5, "b":{"c":"x"}, "e":[1, 2, 3, 2]}`},
+		{bson.Doc{{"$inc", bson.Doc{{"a", math.MaxInt32}}}}, `{"_id":7, "a":2147483648, "b":{"c":"x"}, "e":[1, 2, 3, 2]}`},
+		{bson.Doc{{"$inc", bson.Doc{{"a", bson.Decimal{Value: "0.25"}}}}},
+			`{"_id":7, "a":{"$decimal":"1.25"}, "b":{"c":"x"}, "e":[1, 2, 3, 2]}`},
+		{bson.Doc{{"$inc", bson.Doc{{"e.0", -1}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[0, 2, 3, 2]}`},
+		{bson.Doc{{"$push", bson.Doc{{"e", 4}, {"p", "y"}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 2, 3, 2, 4], "p":["y"]}`},
+		{bson.Doc{{"$push", bson.Doc{{"e", []interface{}{4}}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 2, 3, 2, [4]]}`},
+		{bson.Doc{{"$push_all", bson.Doc{{"e", []interface{}{4, 5}}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 2, 3, 2, 4, 5]}`},
+		{bson.Doc{{"$addtoset", bson.Doc{{"e", []interface{}{3.0, 4, 4}}, {"b.s", []interface{}{1}}}}},
+			`{"_id":7, "a":1, "b":{"c":"x", "s":[1]}, "e":[1, 2, 3, 2, 4]}`},
+		{bson.Doc{{"$pull", bson.Doc{{"e", 2.0}, {"x", 1}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 3]}`},
+		{bson.Doc{{"$pull_all", bson.Doc{{"e", []interface{}{1, 3}}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[2, 2]}`},
+		{bson.Doc{{"$pop", bson.Doc{{"e", 1}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[1, 2, 3]}`},
+		{bson.Doc{{"$pop", bson.Doc{{"e", -2}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[3, 2]}`},
+		{bson.Doc{{"$pop", bson.Doc{{"e", 9}, {"x", 1}}}}, `{"_id":7, "a":1, "b":{"c":"x"}, "e":[]}`},
+		{bson.Doc{{"$rename", bson.Doc{{"a", "z"}, {"b.c", "d"}}}}, `{"_id":7, "z":1, "b":{"d":"x"}, "e":[1, 2, 3, 2]}`},
+		{bson.Doc{{"$rename", bson.Doc{{"a", "e"}}}}, `{"_id":7, "e":1, "b":{"c":"x"}}`},
+		{bson.Doc{{"$replace", bson.Doc{{"r", 1}}}}, `{"_id":7, "r":1}`},
+		{bson.Doc{{"$set", bson.Doc{{"a", 5}}}, {"$inc", bson.Doc{{"a", 1}}}, {"$unset", bson.Doc{{"e", ""}}}},
+			`{"_id":7, "a":6, "b":{"c":"x"}}`},
+	}
+
+	for _, test := range tests {
+		updated, err := bson.ApplyUpdate(doc, test.rule)
+		if err != nil {
+			t.Errorf("update %v: %v", test.rule, err)
+			continue
+		}
+		if err := updated.Validate(); err != nil {
+			t.Errorf("update %v: %v", test.rule, err)
+		}
+		if updated.String() != test.expected {
+			t.Errorf("update %v:\nexpected: %s\n  actual: %s", test.rule, test.expected, updated)
+		}
+		if doc.String() != orig {
+			t.Fatalf("update %v: the document is changed: %s", test.rule, doc)
+		}
+	}
+}
+
+func TestApplyUpdateError(t *testing.T) {
+	doc := bson.Doc{{"_id", 7}, {"a", 1}, {"i", int64(math.MaxInt64)}, {"s", "x"}, {"e", []interface{}{1}}}.Bson()
+
+	var tests = []bson.Doc{
+		{{"$foo", bson.Doc{{"a", 1}}}},
+		{{"$set", 1}},
+		{{"$set", bson.Doc{{"a.b", 1}}}},
+		{{"$inc", bson.Doc{{"s", 1}}}},
+		{{"$inc", bson.Doc{{"a", "1"}}}},
+		{{"$inc", bson.Doc{{"i", 1}}}},
+		{{"$push", bson.Doc{{"a", 1}}}},
+		{{"$push_all", bson.Doc{{"e", 1}}}},
+		{{"$addtoset", bson.Doc{{"e", 1}}}},
+		{{"$pull_all", bson.Doc{{"s", []interface{}{1}}}}},
+		{{"$pop", bson.Doc{{"e", "1"}}}},
+		{{"$rename", bson.Doc{{"a", 1}}}},
+		{{"$rename", bson.Doc{{"a", "b.c"}}}},
+		{{"$replace", bson.Doc{{"_id", 8}}}},
+	}
+
+	for _, rule := range tests {
+		if _, err := bson.ApplyUpdate(doc, rule); err == nil {
+			t.Errorf("update %v: expected error", rule)
+		}
+	}
+}
+
+func TestUpsertDocument(t *testing.T) {
+	var tests = []struct {
+		cond, rule, setOnInsert bson.Doc
+		expected                string
+	}{
+		{bson.Doc{{"a", 1}}, bson.Doc{{"$set", bson.Doc{{"b", 2}}}}, nil, `{"a":1, "b":2}`},
+		{bson.Doc{{"a", 1}}, bson.Doc{{"$set", bson.Doc{{"a", 2}}}}, nil, `{"a":2}`},
+		{bson.Doc{{"a.b", bson.Doc{{"$et", "x"}}}, {"c", bson.Doc{{"$gt", 1}}}}, bson.Doc{{"$inc", bson.Doc{{"n", 1}}}}, nil,
+			`{"a":{"b":"x"}, "n":1}`},
+		{bson.Doc{{"$and", []interface{}{bson.Doc{{"a", 1}}, bson.Doc{{"b", 2}}}}, {"$or", []interface{}{bson.Doc{{"c", 3}}}}},
+			bson.Doc{{"$push", bson.Doc{{"e", 1}}}}, nil, `{"a":1, "b":2, "e":[1]}`},
+		{bson.Doc{{"s", bson.RegEx{Pattern: "^x"}}}, bson.Doc{}, bson.Doc{{"t", 1}}, `{"t":1}`},
+		{bson.Doc{}, bson.Doc{{"$set", bson.Doc{{"a", 1}}}}, bson.Doc{{"a", 2}, {"b", 3}}, `{"a":2, "b":3}`},
+	}
+
+	for _, test := range tests {
+		doc, err := bson.UpsertDocument(test.cond, test.rule, test.setOnInsert)
+		if err != nil {
+			t.Errorf("upsert %v: %v", test.cond, err)
+			continue
+		}
+		if doc.String() != test.expected {
+			t.Errorf("upsert %v:\nexpected: %s\n  actual: %s", test.cond, test.expected, doc)
+		}
+	}
+
+	if _, err := bson.UpsertDocument(bson.Doc{{"a", 1}, {"a.b", 2}}, bson.Doc{}, nil); err == nil {
+		t.Errorf("expected error of conflicting conditions")
+	}
+}
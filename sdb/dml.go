@@ -20,7 +20,7 @@ import (
 	"github.com/davidli2010/gobson_exp/bson"
 )
 
-func buildInsertMsg(cl string, doc bson.Doc) *InsertMsg {
+func buildInsertMsg(cl string, doc *bson.Bson) *InsertMsg {
 	var msg InsertMsg
 	msgLen := msg.FixedSize()
 
@@ -29,7 +29,7 @@ func buildInsertMsg(cl string, doc bson.Doc) *InsertMsg {
 	msg.Name = []byte(cl)
 	msgLen += alignedSize(msg.NameLength+1, 4)
 
-	msg.Doc = doc.Bson()
+	msg.Doc = doc
 	msgLen += alignedSize(int32(msg.Doc.Length()), 4)
 
 	msg.Length = msgLen
@@ -37,7 +37,10 @@ func buildInsertMsg(cl string, doc bson.Doc) *InsertMsg {
 }
 
 func (conn *Conn) Insert(cl string, doc bson.Doc) error {
-	msg := buildInsertMsg(cl, doc)
+	// the doc is only used until the message is encoded
+	b := bson.AcquireBsonBuilder()
+	defer bson.ReleaseBsonBuilder(b)
+	msg := buildInsertMsg(cl, b.AppendDoc(doc).Finish().Bson())
 
 	if err := msg.Encode(conn.conn, conn.order); err != nil {
 		return err
@@ -13,3 +13,36 @@
 // under the License.
 
 package sdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/davidli2010/gobson_exp/bson"
+)
+
+var insertDoc = bson.Doc{
+	{"_id", bson.ObjectId("\x56\x85\xc1\x80\x00\x00\x00\x00\x00\x00\x00\x01")},
+	{"name", "foo"},
+	{"age", 30},
+	{"score", 99.5},
+	{"tags", []interface{}{"a", "b", "c"}},
+	{"address", bson.Doc{{"city", "shenzhen"}, {"zip", "518000"}}},
+}
+
+func BenchmarkSdbInsertMsg(t *testing.B) {
+	for i := 0; i < t.N; i++ {
+		msg := buildInsertMsg("foo.bar", insertDoc.Bson())
+		msg.Encode(ioutil.Discard, binary.LittleEndian)
+	}
+}
+
+func BenchmarkSdbInsertMsgPooled(t *testing.B) {
+	for i := 0; i < t.N; i++ {
+		b := bson.AcquireBsonBuilder()
+		msg := buildInsertMsg("foo.bar", b.AppendDoc(insertDoc).Finish().Bson())
+		msg.Encode(ioutil.Discard, binary.LittleEndian)
+		bson.ReleaseBsonBuilder(b)
+	}
+}